@@ -0,0 +1,102 @@
+package goftp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrAborted is matched by errors.Is when a transfer was cancelled with Abort
+var ErrAborted = errors.New("transfer aborted")
+
+// AbortError is returned by a transfer cancelled with Abort. It matches
+// ErrAborted.
+type AbortError struct {
+	Reason string
+	Bytes  int64 // bytes transferred before the cancellation
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("transfer aborted after %d bytes: %s", e.Bytes, e.Reason)
+}
+
+func (e *AbortError) Unwrap() error {
+	return ErrAborted
+}
+
+// abortState is the data connection of the transfer in progress, which Abort
+// may close from another goroutine
+type abortState struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	reason string
+}
+
+// Abort cancels the download, upload or listing in progress, e.g. from a
+// watchdog goroutine, by closing its data connection. The transfer then
+// returns an *AbortError with reason. An aborted upload may leave a partial
+// file on the server. Abort reports whether there was a transfer to cancel.
+func (ftp *FTP) Abort(reason string) bool {
+	ftp.abort.mu.Lock()
+	defer ftp.abort.mu.Unlock()
+
+	if ftp.abort.conn == nil || ftp.abort.reason != "" {
+		return false
+	}
+	ftp.abort.reason = reason
+	ftp.abort.conn.Close()
+	return true
+}
+
+// startTransfer makes conn the data connection closed by Abort
+func (ftp *FTP) startTransfer(conn net.Conn) {
+	ftp.abort.mu.Lock()
+	ftp.abort.conn, ftp.abort.reason = conn, ""
+	ftp.abort.mu.Unlock()
+}
+
+// failTransfer ends a transfer which failed on the client side, e.g. in a
+// RetrFunc or on a Guard limit: it closes the data connection, so the server
+// completes the transfer, and reads the completion reply
+func (ftp *FTP) failTransfer(pconn net.Conn, err error) error {
+	pconn.Close()
+	return ftp.endTransfer(err, false)
+}
+
+// transferClosed forgets conn once the transfer closed it
+func (ftp *FTP) transferClosed(conn net.Conn) {
+	ftp.abort.mu.Lock()
+	if ftp.abort.conn == conn {
+		ftp.abort.conn = nil
+	}
+	ftp.abort.mu.Unlock()
+}
+
+// endTransfer returns err, or an *AbortError if the transfer was aborted.
+// replied tells whether the completion reply was read already; if not, it is
+// read now to keep the control connection in step, and the data connection
+// must be closed already.
+func (ftp *FTP) endTransfer(err error, replied bool) error {
+	ftp.abort.mu.Lock()
+	reason := ftp.abort.reason
+	ftp.abort.conn, ftp.abort.reason = nil, ""
+	ftp.abort.mu.Unlock()
+
+	if !replied {
+		ftp.setDeadline(time.Now().Add(10 * time.Second))
+		ftp.transferComplete()
+		ftp.setDeadline(time.Time{})
+	}
+
+	if reason == "" {
+		return err
+	}
+
+	aborted := &AbortError{Reason: reason}
+	if ftp.lastTransfer != nil {
+		aborted.Bytes = ftp.lastTransfer.Bytes
+	}
+	return aborted
+}
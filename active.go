@@ -0,0 +1,144 @@
+package goftp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// DataMode tells how data connections are established
+type DataMode int
+
+// The data connection modes
+const (
+	// PassiveMode, the default, connects to a port opened by the server
+	// after PASV
+	PassiveMode DataMode = iota
+	// ActiveMode listens on a local port announced with PORT, or EPRT for
+	// IPv6, and accepts the connection of the server
+	ActiveMode
+)
+
+// activeTimeout bounds the wait for the server to connect in active mode
+const activeTimeout = 10 * time.Second
+
+// SetDataMode selects how the following transfers and listings establish
+// their data connection. Some servers, often behind corporate firewalls,
+// only work in active mode.
+func (ftp *FTP) SetDataMode(mode DataMode) {
+	ftp.dataMode = mode
+}
+
+// WithDataMode runs fn, which uses the session, with the data mode set to
+// mode, e.g. for a single transfer, and restores the previous mode after
+func (ftp *FTP) WithDataMode(mode DataMode, fn func() error) error {
+	previous := ftp.dataMode
+	ftp.dataMode = mode
+	defer func() { ftp.dataMode = previous }()
+	return fn()
+}
+
+// SetActivePortRange makes active mode listen on a port within [min, max],
+// e.g. the ports a firewall lets in. Zero for both, the default, listens on
+// any port.
+func (ftp *FTP) SetActivePortRange(min, max int) {
+	ftp.activeMin, ftp.activeMax = min, max
+}
+
+// SetActiveAddress sets the IP address announced to the server in active
+// mode, for clients behind NAT whose external address differs from the
+// local address of the control connection. An empty address, the default,
+// announces the local one.
+func (ftp *FTP) SetActiveAddress(ip string) {
+	ftp.activeAddr = ip
+}
+
+// listenActive opens the local port of an active data connection and
+// announces it to the server
+func (ftp *FTP) listenActive() (net.Listener, error) {
+	local, ok := ftp.conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, errors.New("active mode needs a TCP control connection")
+	}
+
+	listener, err := listenInRange(local.IP, ftp.activeMin, ftp.activeMax)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := local.IP
+	if ftp.activeAddr != "" {
+		if ip = net.ParseIP(ftp.activeAddr); ip == nil {
+			listener.Close()
+			return nil, fmt.Errorf("invalid active address %q", ftp.activeAddr)
+		}
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	if ip4 := ip.To4(); ip4 != nil {
+		_, err = ftp.cmd(StatusOK, "PORT %d,%d,%d,%d,%d,%d", ip4[0], ip4[1], ip4[2], ip4[3], port>>8, port&0xff)
+	} else {
+		_, err = ftp.cmd(StatusOK, "EPRT |2|%s|%d|", ip, port)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// listenInRange listens on ip, on the first free port of [min, max], or any
+// port if max is zero
+func listenInRange(ip net.IP, min, max int) (net.Listener, error) {
+	if max <= 0 {
+		return net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+	}
+
+	var err error
+	for port := min; port <= max; port++ {
+		var listener net.Listener
+		if listener, err = net.ListenTCP("tcp", &net.TCPAddr{IP: ip, Port: port}); err == nil {
+			return listener, nil
+		}
+	}
+	return nil, fmt.Errorf("no free port for active mode in %d-%d: %v", min, max, err)
+}
+
+// acceptActive waits for the server to connect to listener. Connections from
+// other hosts than the server, which could inject or steal the data, are
+// rejected.
+func (ftp *FTP) acceptActive(listener net.Listener) (net.Conn, error) {
+	server, ok := ftp.conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, errors.New("active mode needs a TCP control connection")
+	}
+	if tcp, ok := listener.(*net.TCPListener); ok {
+		tcp.SetDeadline(time.Now().Add(activeTimeout))
+	}
+
+	var conn net.Conn
+	for {
+		var err error
+		if conn, err = listener.Accept(); err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil, errors.New("ActiveTimeout")
+			}
+			return nil, err
+		}
+		if peer, ok := conn.RemoteAddr().(*net.TCPAddr); ok && peer.IP.Equal(server.IP) {
+			break
+		}
+		if ftp.debug {
+			log.Printf("Rejected data connection from %s\n", conn.RemoteAddr())
+		}
+		conn.Close()
+	}
+
+	if ftp.debug {
+		log.Printf("Accepted data connection from %s\n", conn.RemoteAddr())
+	}
+	return ftp.secureData(conn), nil
+}
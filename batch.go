@@ -0,0 +1,78 @@
+package goftp
+
+import (
+	"fmt"
+)
+
+// ItemError is the failure of a single path within a batch operation
+type ItemError struct {
+	Path string
+	Code StatusCode // reply code of the failed command, or 0 if there was none
+	Err  error
+}
+
+func (e *ItemError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError aggregates the failures of a batch operation. errors.Is and
+// errors.As look through every member.
+type BatchError struct {
+	Errors []*ItemError
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	return fmt.Sprintf("%d operations failed; first: %s", len(e.Errors), e.Errors[0])
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, item := range e.Errors {
+		errs[i] = item
+	}
+	return errs
+}
+
+// add records the failure of path, if err is not nil
+func (e *BatchError) add(path string, err error) {
+	if err != nil {
+		e.Errors = append(e.Errors, &ItemError{Path: path, Code: replyCode(err), Err: err})
+	}
+}
+
+// err returns e, or nil if nothing failed
+func (e *BatchError) err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// DeleteMany deletes every path on the remote host, continuing past failures.
+// The failures are returned as a *BatchError. Each path is deleted with its
+// own DELE: no server advertises a bulk deletion in FEAT which would tell
+// which paths failed, unlike SITE RMDIR for a whole tree, see RemoveAll.
+func (ftp *FTP) DeleteMany(paths []string) error {
+	batch := &BatchError{}
+	for _, path := range paths {
+		batch.add(path, ftp.Dele(path))
+	}
+	return batch.err()
+}
+
+// UploadMany uploads every local path as Upload does, continuing past
+// failures. The failures are returned as a *BatchError.
+func (ftp *FTP) UploadMany(localPaths []string) error {
+	batch := &BatchError{}
+	for _, path := range localPaths {
+		batch.add(path, ftp.Upload(path))
+	}
+	return batch.err()
+}
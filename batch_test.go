@@ -0,0 +1,27 @@
+package goftp
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestBatchError(t *testing.T) {
+	batch := &BatchError{}
+	batch.add("a", nil)
+	if batch.err() != nil {
+		t.Fatal("empty batch should not be an error")
+	}
+
+	batch.add("a", errors.New("550 No such file or directory.\r\n"))
+	batch.add("b", os.ErrNotExist)
+	err := batch.err()
+
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Error("errors.Is should find a member error")
+	}
+	var item *ItemError
+	if !errors.As(err, &item) || item.Path != "a" || item.Code != 550 {
+		t.Errorf("unexpected first item %+v", item)
+	}
+}
@@ -0,0 +1,149 @@
+package goftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	pathpkg "path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BenchmarkResult is the outcome of Benchmark, per direction
+type BenchmarkResult struct {
+	Upload   ThroughputStats
+	Download ThroughputStats
+}
+
+// ThroughputStats summarizes the transfers of one direction of a benchmark
+type ThroughputStats struct {
+	Transfers int
+	Bytes     int64
+	// Elapsed is the wall time of all the transfers, run in parallel
+	Elapsed        time.Duration
+	BytesPerSecond float64
+	// Percentiles of the duration of a single transfer
+	P50, P90, P99 time.Duration
+}
+
+// Benchmark qualifies an FTP endpoint: parallelism sessions obtained from
+// open each upload size bytes of random data to a file below path, the
+// remote folder to use, iterations times, then download it back as many
+// times, and finally delete it, also when the benchmark fails. Uploads and
+// downloads are timed separately, for the aggregated throughput and the
+// latency percentiles of a transfer. The sessions are closed once done.
+func Benchmark(open func() (*FTP, error), path string, size int64, parallelism, iterations int) (result *BenchmarkResult, err error) {
+	if path == "" {
+		return nil, errors.New("benchmark needs a remote path")
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if iterations < 1 {
+		iterations = 1
+	}
+
+	sessions := make([]*FTP, 0, parallelism)
+	defer func() {
+		for _, ftp := range sessions {
+			ftp.Quit()
+		}
+	}()
+	for i := 0; i < parallelism; i++ {
+		ftp, err := open()
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, ftp)
+	}
+
+	name := func(i int) string {
+		return pathpkg.Join(path, fmt.Sprintf("goftp-benchmark-%d", i))
+	}
+	defer func() {
+		// Files are removed whatever failed, only reporting errors of a
+		// benchmark which went fine
+		for i, ftp := range sessions {
+			if dErr := ftp.Dele(name(i)); err == nil && dErr != nil {
+				result, err = nil, dErr
+			}
+		}
+	}()
+
+	upload, err := benchmarkPhase(sessions, size, iterations, func(i int, ftp *FTP) error {
+		return ftp.Stor(name(i), io.LimitReader(rand.New(rand.NewSource(int64(i))), size))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	download, err := benchmarkPhase(sessions, size, iterations, func(i int, ftp *FTP) error {
+		_, err := ftp.RetrTo(name(i), io.Discard)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &BenchmarkResult{Upload: upload, Download: download}, nil
+}
+
+// benchmarkPhase runs transfer iterations times on every session in parallel
+// and times it
+func benchmarkPhase(sessions []*FTP, size int64, iterations int, transfer func(i int, ftp *FTP) error) (ThroughputStats, error) {
+	durations := make([]time.Duration, len(sessions)*iterations)
+	errs := make([]error, len(sessions))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, ftp := range sessions {
+		wg.Add(1)
+		go func(i int, ftp *FTP) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				t := time.Now()
+				if errs[i] = transfer(i, ftp); errs[i] != nil {
+					return
+				}
+				durations[i*iterations+j] = time.Since(t)
+			}
+		}(i, ftp)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	for _, err := range errs {
+		if err != nil {
+			return ThroughputStats{}, err
+		}
+	}
+
+	stats := ThroughputStats{
+		Transfers: len(durations),
+		Bytes:     size * int64(len(durations)),
+		Elapsed:   elapsed,
+	}
+	if elapsed > 0 {
+		stats.BytesPerSecond = float64(stats.Bytes) / elapsed.Seconds()
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	stats.P50 = percentile(durations, 50)
+	stats.P90 = percentile(durations, 90)
+	stats.P99 = percentile(durations, 99)
+	return stats, nil
+}
+
+// percentile returns the p-th percentile of sorted durations, nearest rank
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
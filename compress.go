@@ -0,0 +1,69 @@
+package goftp
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// Compressor compresses files client-side for StorCompressed and
+// RetrCompressed
+type Compressor interface {
+	// Extension is appended to remote file names, e.g. ".gz"
+	Extension() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip is the gzip Compressor
+var Gzip Compressor = gzipCompressor{}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Extension() string {
+	return ".gz"
+}
+
+func (gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// StorCompressed uploads r compressed with c to path plus the compressor's
+// extension
+func (ftp *FTP) StorCompressed(path string, r io.Reader, c Compressor) error {
+	pr, pw := io.Pipe()
+	go func() {
+		cw, err := c.NewWriter(pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err = io.Copy(cw, r); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(cw.Close())
+	}()
+
+	err := ftp.Stor(path+c.Extension(), pr)
+	// Unblock the compressing goroutine if Stor stopped reading
+	pr.Close()
+	return err
+}
+
+// RetrCompressed retrieves path plus the compressor's extension, passing
+// retrFn the decompressed content
+func (ftp *FTP) RetrCompressed(path string, c Compressor, retrFn RetrFunc) error {
+	_, err := ftp.Retr(path+c.Extension(), func(r io.Reader) error {
+		cr, err := c.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer cr.Close()
+		return retrFn(cr)
+	})
+	return err
+}
@@ -0,0 +1,222 @@
+package goftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// deadlineState is the deadline of the context the session runs under, which
+// bounds the temporary deadlines set on the control connection
+type deadlineState struct {
+	mu  sync.Mutex
+	ctx time.Time // zero outside RunContext
+}
+
+// RunContext runs fn, which uses the session, under ctx: the deadline of ctx
+// bounds the replies awaited by fn, and cancelling ctx interrupts the command
+// or aborts the transfer in progress. The error of an interrupted fn matches
+// ctx.Err(), and *AbortError for an aborted transfer. After an interruption
+// the control connection may be out of step with the server, and the session
+// should be closed.
+func (ftp *FTP) RunContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	previous := ftp.runCtx
+	ftp.runCtx = ctx
+	defer func() { ftp.runCtx = previous }()
+
+	stop := watchContext(ctx, ftp.setContextDeadline, func() { ftp.Abort(context.Cause(ctx).Error()) })
+	return contextError(ctx, stop(fn()))
+}
+
+// setContextDeadline makes t the deadline of the control connection and the
+// bound of the temporary deadlines set with setDeadline
+func (ftp *FTP) setContextDeadline(t time.Time) {
+	ftp.deadline.mu.Lock()
+	defer ftp.deadline.mu.Unlock()
+	ftp.deadline.ctx = t
+	ftp.conn.SetDeadline(t)
+}
+
+// setDeadline sets a temporary deadline on the control connection, e.g. for
+// a login command, which does not extend past the deadline of the running
+// context. The zero time ends it, restoring the deadline of the context.
+func (ftp *FTP) setDeadline(t time.Time) error {
+	ftp.deadline.mu.Lock()
+	defer ftp.deadline.mu.Unlock()
+	if ctx := ftp.deadline.ctx; !ctx.IsZero() && (t.IsZero() || ctx.Before(t)) {
+		t = ctx
+	}
+	return ftp.conn.SetDeadline(t)
+}
+
+// ConnectContext connects to server at addr (format "host:port") like
+// Connect, under ctx. debug is OFF
+func ConnectContext(ctx context.Context, addr string) (*FTP, error) {
+	return ConnectViaContext(ctx, addr, nil)
+}
+
+// ConnectViaContext connects to server at addr (format "host:port") like
+// ConnectVia, under ctx. The session keeps using dial for its data
+// connections. debug is OFF
+func ConnectViaContext(ctx context.Context, addr string, dial DialFunc) (*FTP, error) {
+	conn, err := dialContext(ctx, dial, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The greeting is awaited under ctx too
+	stop := watchContext(ctx, func(t time.Time) { conn.SetDeadline(t) }, nil)
+	ftp := newSession(conn, addr, dial, false)
+	if err = stop(nil); err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ftp, nil
+}
+
+// dialContext connects to addr with dial, or a net.Dialer if nil, under ctx.
+// A DialFunc cannot be interrupted: when ctx ends first, its connection is
+// closed once established.
+func dialContext(ctx context.Context, dial DialFunc, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	if ctx.Done() == nil {
+		return dial(network, addr)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := dial(network, addr)
+		done <- result{conn, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// LoginContext logs in like Login, under ctx
+func (ftp *FTP) LoginContext(ctx context.Context, username string, password string) error {
+	return ftp.RunContext(ctx, func() error {
+		return ftp.Login(username, password)
+	})
+}
+
+// ListContext lists the path (or current directory) like List, under ctx
+func (ftp *FTP) ListContext(ctx context.Context, path string) (entries []*Entry, err error) {
+	err = ftp.RunContext(ctx, func() (err error) {
+		entries, err = ftp.List(path)
+		return
+	})
+	return
+}
+
+// RetrContext retrieves file from remote host at path like Retr, under ctx
+func (ftp *FTP) RetrContext(ctx context.Context, path string, retrFn RetrFunc) error {
+	return ftp.RunContext(ctx, func() error {
+		_, err := ftp.Retr(path, retrFn)
+		return err
+	})
+}
+
+// StorContext uploads file to remote host path from r like Stor, under ctx
+func (ftp *FTP) StorContext(ctx context.Context, path string, r io.Reader) error {
+	return ftp.RunContext(ctx, func() error {
+		return ftp.Stor(path, r)
+	})
+}
+
+// WalkContext walks recursively through path like Walk, under ctx
+func (ftp *FTP) WalkContext(ctx context.Context, path string, walkFn WalkFunc) error {
+	return ftp.RunContext(ctx, func() error {
+		return ftp.Walk(path, walkFn)
+	})
+}
+
+// WalkEntriesContext walks the remote tree at root like WalkEntries, under
+// ctx
+func (ftp *FTP) WalkEntriesContext(ctx context.Context, root string, fn WalkEntryFunc) error {
+	return ftp.RunContext(ctx, func() error {
+		return ftp.WalkEntries(root, fn)
+	})
+}
+
+// watchContext applies ctx to a connection with setDeadline until stop is
+// called: the deadline of ctx becomes the deadline of the connection, and
+// cancelling ctx calls cancel, if not nil, and makes the pending reads and
+// writes of the connection fail. stop returns its argument.
+func watchContext(ctx context.Context, setDeadline func(time.Time), cancel func()) (stop func(error) error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		setDeadline(deadline)
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			if cancel != nil {
+				cancel()
+			}
+			// A deadline in the past unblocks the pending operations
+			setDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func(err error) error {
+		close(done)
+		<-exited
+		setDeadline(time.Time{})
+		return err
+	}
+}
+
+// contextError makes err match ctx.Err() if ctx ended
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		// The connection deadline, that of ctx, may pass just before ctx
+		// reports it
+		var netErr net.Error
+		deadline, ok := ctx.Deadline()
+		if !ok || !errors.As(err, &netErr) || !netErr.Timeout() || time.Now().Before(deadline) {
+			return err
+		}
+		ctxErr = context.DeadlineExceeded
+	}
+	return fmt.Errorf("%w: %w", ctxErr, err)
+}
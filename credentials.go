@@ -0,0 +1,37 @@
+package goftp
+
+// CredentialProvider supplies the username and password to log in with. It is
+// asked on every login, so secrets can be fetched from a vault, rotated or
+// prompted for without rebuilding the caller's configuration.
+type CredentialProvider interface {
+	Credentials() (username string, password string, err error)
+}
+
+// CredentialFunc adapts a function, such as a password prompt, to a
+// CredentialProvider
+type CredentialFunc func() (username string, password string, err error)
+
+// Credentials calls f
+func (f CredentialFunc) Credentials() (string, string, error) {
+	return f()
+}
+
+// StaticCredentials is a CredentialProvider for a fixed username and password
+type StaticCredentials struct {
+	Username string
+	Password string
+}
+
+// Credentials returns the fixed username and password
+func (c StaticCredentials) Credentials() (string, string, error) {
+	return c.Username, c.Password, nil
+}
+
+// LoginWith logs in with the credentials returned by provider
+func (ftp *FTP) LoginWith(provider CredentialProvider) error {
+	username, password, err := provider.Credentials()
+	if err != nil {
+		return err
+	}
+	return ftp.Login(username, password)
+}
@@ -0,0 +1,179 @@
+package goftp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafeName is matched by errors.Is when DownloadDir meets a remote name
+// which is not a single local path element, such as "../file", and would be
+// written outside the local folder
+var ErrUnsafeName = errors.New("unsafe remote name")
+
+// CollisionPolicy tells DownloadDir what to do with a file which already
+// exists locally
+type CollisionPolicy int
+
+// The collision policies
+const (
+	// CollisionOverwrite replaces the local file
+	CollisionOverwrite CollisionPolicy = iota
+	// CollisionSkip keeps the local file and does not download
+	CollisionSkip
+	// CollisionRename downloads next to the local file, with a numbered
+	// suffix: "report.csv" becomes "report-1.csv"
+	CollisionRename
+	// CollisionError stops the download with an error matching os.ErrExist
+	CollisionError
+)
+
+// DownloadOutcome is what DownloadDir did with a remote file
+type DownloadOutcome int
+
+// The outcomes reported by DownloadDir
+const (
+	Downloaded DownloadOutcome = iota
+	DownloadOverwritten
+	DownloadSkipped
+	DownloadRenamed
+)
+
+// DownloadResult describes a file handled by DownloadDir
+type DownloadResult struct {
+	Path      string // remote path
+	LocalPath string // where the file was written, or the file kept if skipped
+	Outcome   DownloadOutcome
+}
+
+// DownloadDir recursively downloads the remote folder at remotePath into the
+// local folder at localPath, creating folders as needed. Files existing
+// locally are handled according to policy. The results of the files handled
+// so far are returned with any error.
+//
+// Nothing is downloaded if a remote name could escape localPath. Files are
+// written to a temporary file first, so a failed download leaves an existing
+// local file untouched.
+func (ftp *FTP) DownloadDir(remotePath, localPath string, policy CollisionPolicy) ([]DownloadResult, error) {
+	var files []string
+	var unsafe error
+	err := ftp.walkEntries(remotePath, "", func(rel string, e *Entry) {
+		if e.Type != EntryTypeFile || unsafe != nil {
+			return
+		}
+		if !isLocalName(e.Name) || !isLocalPath(rel) {
+			unsafe = fmt.Errorf("%w: %q", ErrUnsafeName, pathpkg.Join(remotePath, rel))
+			return
+		}
+		files = append(files, rel)
+	})
+	if err == nil {
+		err = unsafe
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Clean(localPath)
+	prefix := root
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+
+	var results []DownloadResult
+	for _, rel := range files {
+		result := DownloadResult{
+			Path:      pathpkg.Join(remotePath, rel),
+			LocalPath: filepath.Join(root, filepath.FromSlash(rel)),
+		}
+		if !strings.HasPrefix(result.LocalPath, prefix) {
+			return results, fmt.Errorf("%w: %q", ErrUnsafeName, result.Path)
+		}
+		if err = os.MkdirAll(filepath.Dir(result.LocalPath), 0o755); err != nil {
+			return results, err
+		}
+
+		if _, err = os.Lstat(result.LocalPath); err == nil {
+			switch policy {
+			case CollisionSkip:
+				result.Outcome = DownloadSkipped
+				results = append(results, result)
+				continue
+			case CollisionRename:
+				if result.LocalPath, err = freeName(result.LocalPath); err != nil {
+					return results, err
+				}
+				result.Outcome = DownloadRenamed
+			case CollisionError:
+				return results, fmt.Errorf("%w: %s", os.ErrExist, result.LocalPath)
+			default:
+				result.Outcome = DownloadOverwritten
+			}
+		} else if !os.IsNotExist(err) {
+			return results, err
+		}
+
+		if err = ftp.downloadFile(result.Path, result.LocalPath); err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// downloadFile retrieves path into a temporary file next to localPath, then
+// renames it into place
+func (ftp *FTP) downloadFile(path, localPath string) error {
+	file, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err = file.Chmod(0o644); err == nil {
+		_, err = ftp.RetrTo(path, file)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), localPath)
+}
+
+// isLocalName reports whether name, from a remote listing, is a single local
+// path element
+func isLocalName(name string) bool {
+	return filepath.IsLocal(name) && !strings.ContainsAny(name, `/\`)
+}
+
+// isLocalPath reports whether every element of the slash separated path rel
+// is a single local path element
+func isLocalPath(rel string) bool {
+	for _, name := range strings.Split(rel, "/") {
+		if !isLocalName(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// freeName returns the first name not taken locally among path with a
+// numbered suffix before its extension
+func freeName(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
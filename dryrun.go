@@ -0,0 +1,195 @@
+package goftp
+
+import (
+	"io"
+	"log"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"time"
+)
+
+// DryRun wraps a session to preview scripts: mutating operations only log
+// the command they would send and succeed, while reads go to the server. The
+// wrapped session is made read-only, so a mutating operation not covered by
+// DryRun, like StorEncrypted, StorResumable, SetFacts, UploadWithManifest or
+// a RawCmd, fails with ErrReadOnly instead of changing the server.
+type DryRun struct {
+	*FTP
+
+	logger   *log.Logger
+	readOnly bool // of the session before NewDryRun
+}
+
+// NewDryRun wraps ftp, logging to logger or to the standard logger if nil.
+// The session stays read-only until Release is called.
+func NewDryRun(ftp *FTP, logger *log.Logger) *DryRun {
+	d := &DryRun{FTP: ftp, logger: logger, readOnly: ftp.readOnly}
+	ftp.SetReadOnly(true)
+	return d
+}
+
+// Release ends the dry run and returns the session, read-only again only if
+// it was before NewDryRun
+func (d *DryRun) Release() *FTP {
+	d.FTP.SetReadOnly(d.readOnly)
+	return d.FTP
+}
+
+func (d *DryRun) logf(format string, args ...interface{}) {
+	if d.logger != nil {
+		d.logger.Printf("dry-run: "+format, args...)
+	} else {
+		log.Printf("dry-run: "+format, args...)
+	}
+}
+
+// Rename logs the RNFR and RNTO commands
+func (d *DryRun) Rename(from string, to string) error {
+	d.logf("RNFR %s", d.remotePath(from))
+	d.logf("RNTO %s", d.remotePath(to))
+	return nil
+}
+
+// Mkd logs the MKD command
+func (d *DryRun) Mkd(path string) error {
+	d.logf("MKD %s", d.remotePath(path))
+	return nil
+}
+
+// Rmd logs the RMD command
+func (d *DryRun) Rmd(path string) error {
+	d.logf("RMD %s", d.remotePath(path))
+	return nil
+}
+
+// Dele logs the DELE command
+func (d *DryRun) Dele(path string) error {
+	d.logf("DELE %s", d.remotePath(path))
+	return nil
+}
+
+// DeleteMany logs a DELE command per path
+func (d *DryRun) DeleteMany(paths []string) error {
+	for _, path := range paths {
+		d.Dele(path)
+	}
+	return nil
+}
+
+// Stor logs the STOR command without reading r
+func (d *DryRun) Stor(path string, r io.Reader) error {
+	d.logf("STOR %s", d.remotePath(path))
+	return nil
+}
+
+// StorFrom logs the REST and STOR commands without reading r
+func (d *DryRun) StorFrom(path string, r io.Reader, offset uint64) error {
+	d.logf("REST %d", offset)
+	d.logf("STOR %s", d.remotePath(path))
+	return nil
+}
+
+// Mfmt logs the MFMT command
+func (d *DryRun) Mfmt(path string, t time.Time) error {
+	d.logf("MFMT %s %s", t.UTC().Format("20060102150405"), d.remotePath(path))
+	return nil
+}
+
+// Chmod logs the SITE CHMOD command
+func (d *DryRun) Chmod(path string, mode os.FileMode) error {
+	d.logf("SITE CHMOD %o %s", mode.Perm(), d.remotePath(path))
+	return nil
+}
+
+// StorWithMetadata logs the STOR command, then the MFMT and SITE CHMOD
+// commands for a non-zero modTime and mode
+func (d *DryRun) StorWithMetadata(path string, r io.Reader, modTime time.Time, mode os.FileMode) error {
+	d.Stor(path, r)
+	if !modTime.IsZero() {
+		d.Mfmt(path, modTime)
+	}
+	if mode != 0 {
+		d.Chmod(path, mode)
+	}
+	return nil
+}
+
+// StorCompressed logs the STOR command of the compressed file
+func (d *DryRun) StorCompressed(path string, r io.Reader, c Compressor) error {
+	return d.Stor(path+c.Extension(), r)
+}
+
+// Lock logs the STOR command of the lock marker, without checking whether
+// the lock is held
+func (d *DryRun) Lock(path string, staleAfter time.Duration) error {
+	return d.Stor(path+LockSuffix, nil)
+}
+
+// Unlock logs the DELE command of the lock marker
+func (d *DryRun) Unlock(path string) error {
+	return d.Dele(path + LockSuffix)
+}
+
+// StorLocked logs the commands taking the lock, uploading and releasing it
+func (d *DryRun) StorLocked(path string, r io.Reader, staleAfter time.Duration) error {
+	d.Lock(path, staleAfter)
+	d.Stor(path, r)
+	return d.Unlock(path)
+}
+
+// RemoveAll lists the tree at path on the server and logs the DELE and RMD
+// commands removing it one entry at a time
+func (d *DryRun) RemoveAll(path string) error {
+	if err := d.checkPath(path); err != nil {
+		return err
+	}
+	entries, err := d.List(path)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if isSelfOrParent(e) {
+			continue
+		}
+		child := pathpkg.Join(path, e.Name)
+		if e.Type == EntryTypeFolder {
+			if err = d.RemoveAll(child); err != nil {
+				return err
+			}
+		} else {
+			d.Dele(child)
+		}
+	}
+	return d.Rmd(path)
+}
+
+// Upload logs the MKD and STOR commands uploading the file or tree at
+// localPath
+func (d *DryRun) Upload(localPath string) error {
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	stor := func(localPath, serverPath string) error {
+		return d.Stor(serverPath, nil)
+	}
+	switch {
+	case fi.IsDir():
+		return d.copyDir(localPath, d.Mkd, stor)
+	case fi.Mode()&os.ModeType == 0:
+		return stor(localPath, filepath.Base(localPath))
+	}
+	return nil
+}
+
+// UploadMany logs the local paths which would be uploaded
+func (d *DryRun) UploadMany(localPaths []string) error {
+	batch := &BatchError{}
+	for _, path := range localPaths {
+		batch.add(path, d.Upload(path))
+	}
+	return batch.err()
+}
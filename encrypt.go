@@ -0,0 +1,245 @@
+package goftp
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted files start with a header of the format version and a random
+// salt, followed by AES-GCM sealed chunks of encChunkSize plaintext bytes.
+// Each file is sealed with its own key, derived from the caller's key and the
+// salt with HKDF-SHA256, so nonces never repeat under a key however many files
+// are encrypted. The nonce of each chunk is the chunk counter and a flag
+// marking the last chunk, so reordered or truncated files fail to decrypt.
+const (
+	encVersion     = 1
+	encSaltSize    = 32
+	encHeaderSize  = 1 + encSaltSize
+	encChunkSize   = 64 * 1024
+	encNonceSize   = 12
+	encCounterSize = 4
+	encTagSize     = 16
+)
+
+// encInfo binds the derived keys to this file format
+var encInfo = []byte("goftp encrypted file v1")
+
+// ErrDecrypt is returned when an encrypted file cannot be authenticated with
+// the given key, e.g. because the key is wrong or the file was modified.
+var ErrDecrypt = errors.New("cannot decrypt: wrong key or corrupted file")
+
+// StorEncrypted uploads r to path encrypted with AES-GCM under key, which must
+// be 16, 24 or 32 bytes long. The content is never sent in clear text, so it
+// is protected even on plain FTP servers.
+func (ftp *FTP) StorEncrypted(path string, r io.Reader, key []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := newEncryptWriter(pw, key)
+		if err == nil {
+			if _, err = io.Copy(w, r); err == nil {
+				err = w.Close()
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err := ftp.Stor(path, pr)
+	// Unblock the encrypting goroutine if Stor stopped reading
+	pr.Close()
+	return err
+}
+
+// RetrEncrypted retrieves path, stored with StorEncrypted under key, passing
+// retrFn the decrypted content. Tampering is reported as ErrDecrypt.
+func (ftp *FTP) RetrEncrypted(path string, key []byte, retrFn RetrFunc) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	_, err := ftp.Retr(path, func(r io.Reader) error {
+		return retrFn(newDecryptReader(r, key))
+	})
+	return err
+}
+
+func checkKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return aes.KeySizeError(len(key))
+}
+
+// fileAEAD returns the AEAD of the file with salt, under a key of the same
+// length as key derived from it
+func fileAEAD(key, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(hkdfSHA256(key, salt, encInfo, len(key)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hkdfSHA256 derives n bytes from secret with HKDF-SHA256 (RFC 5869). n is at
+// most the SHA-256 size, so a single expand step is enough.
+func hkdfSHA256(secret, salt, info []byte, n int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:n]
+}
+
+// chunkNonce returns the nonce of chunk counter
+func chunkNonce(nonce []byte, counter uint32, last bool) []byte {
+	binary.BigEndian.PutUint32(nonce[encNonceSize-encCounterSize-1:], counter)
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint32
+	buf     []byte
+}
+
+func newEncryptWriter(w io.Writer, key []byte) (*encryptWriter, error) {
+	header := make([]byte, encHeaderSize)
+	header[0] = encVersion
+	if _, err := rand.Read(header[1:]); err != nil {
+		return nil, err
+	}
+	aead, err := fileAEAD(key, header[1:])
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, nonce: make([]byte, encNonceSize), buf: make([]byte, 0, encChunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data shows it is not the last
+		if len(e.buf) == encChunkSize {
+			if err = e.seal(false); err != nil {
+				return
+			}
+		}
+		c := copy(e.buf[len(e.buf):encChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return
+}
+
+// Close seals the last chunk. It does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.nonce, e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	key     []byte
+	aead    cipher.AEAD // set once the header is read
+	nonce   []byte
+	counter uint32
+	plain   []byte
+	done    bool
+	err     error
+}
+
+func newDecryptReader(r io.Reader, key []byte) *decryptReader {
+	return &decryptReader{
+		r:     bufio.NewReaderSize(r, encChunkSize+encTagSize+1),
+		key:   key,
+		nonce: make([]byte, encNonceSize),
+	}
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk
+func (d *decryptReader) open() error {
+	if d.aead == nil {
+		header := make([]byte, encHeaderSize)
+		if _, err := io.ReadFull(d.r, header); err != nil {
+			return ErrDecrypt
+		}
+		if header[0] != encVersion {
+			return fmt.Errorf("%w: unknown format version %d", ErrDecrypt, header[0])
+		}
+		aead, err := fileAEAD(d.key, header[1:])
+		if err != nil {
+			return err
+		}
+		d.aead = aead
+	}
+
+	sealed := make([]byte, encChunkSize+encTagSize)
+	n, err := io.ReadFull(d.r, sealed)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			// The last chunk is missing
+			return ErrDecrypt
+		}
+		return err
+	}
+
+	last := n < len(sealed)
+	if !last {
+		if _, err = d.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+
+	plain, err := d.aead.Open(nil, chunkNonce(d.nonce, d.counter, last), sealed[:n], nil)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
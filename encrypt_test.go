@@ -0,0 +1,52 @@
+package goftp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+
+	for _, size := range []int{0, 1, encChunkSize, encChunkSize + 1, 3*encChunkSize - 5} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		var sealed bytes.Buffer
+		w, err := newEncryptWriter(&sealed, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(plain)
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := io.ReadAll(newDecryptReader(bytes.NewReader(sealed.Bytes()), key))
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("size %d: round trip failed: %v", size, err)
+		}
+
+		// Dropping the tail must not go unnoticed
+		truncated := sealed.Bytes()[:sealed.Len()-1]
+		if _, err = io.ReadAll(newDecryptReader(bytes.NewReader(truncated), key)); err != ErrDecrypt {
+			t.Errorf("size %d: truncated file gave %v", size, err)
+		}
+	}
+}
+
+func TestHKDF(t *testing.T) {
+	// RFC 5869, test case 1, first 32 bytes of the OKM
+	secret := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf"
+
+	if got := hex.EncodeToString(hkdfSHA256(secret, salt, info, 32)); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package goftp
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+var entryTypeNames = map[EntryType]string{
+	EntryTypeFile:   "file",
+	EntryTypeFolder: "folder",
+	EntryTypeLink:   "link",
+}
+
+func (t EntryType) String() string {
+	return entryTypeNames[t]
+}
+
+// MarshalText encodes the type by name, e.g. in JSON listings
+func (t EntryType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes a type encoded by MarshalText, e.g. when reading back
+// a JSON listing
+func (t *EntryType) UnmarshalText(text []byte) error {
+	for typ, name := range entryTypeNames {
+		if name == string(text) {
+			*t = typ
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown entry type %q", text)
+}
+
+// entryCSVHeader names the columns written by WriteEntriesCSV
+var entryCSVHeader = []string{"name", "type", "size", "time", "perm", "owner", "group", "target", "raw"}
+
+// WriteEntriesJSON writes entries to w as a JSON array
+func WriteEntriesJSON(w io.Writer, entries []*Entry) error {
+	if entries == nil {
+		entries = []*Entry{}
+	}
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// WriteEntriesCSV writes entries to w as CSV with a header line. Times are
+// formatted as RFC 3339.
+func WriteEntriesCSV(w io.Writer, entries []*Entry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(entryCSVHeader); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		record := []string{
+			e.Name,
+			e.Type.String(),
+			strconv.FormatUint(e.Size, 10),
+			e.Time.Format(time.RFC3339),
+			e.Perm,
+			e.Owner,
+			e.Group,
+			e.Target,
+			e.Raw,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,34 @@
+package goftp
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEntriesJSONRoundTrip(t *testing.T) {
+	entries := []*Entry{
+		{Name: "a.txt", Type: EntryTypeFile, Size: 42, Time: time.Date(2015, 8, 13, 22, 48, 45, 0, time.UTC), Perm: "-rw-r--r--"},
+		{Name: "dir", Type: EntryTypeFolder},
+		{Name: "link", Type: EntryTypeLink, Target: "a.txt"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteEntriesJSON(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []*Entry
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, entries) {
+		t.Errorf("got %+v, want %+v", decoded, entries)
+	}
+
+	var typ EntryType
+	if err := typ.UnmarshalText([]byte("socket")); err == nil {
+		t.Error("unknown type accepted")
+	}
+}
@@ -0,0 +1,309 @@
+package goftp
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNotSupported is matched by errors.Is when a command needs an extension
+// the server does not advertise in FEAT
+var ErrNotSupported = errors.New("not supported by the server")
+
+// hashPreference lists the HASH algorithms known to the client, strongest
+// first
+var hashPreference = []string{"SHA-512", "SHA-256", "SHA-1", "MD5", "CRC32"}
+
+// Features returns the extensions advertised by the server in reply to FEAT,
+// keyed by upper-case name, with their parameters as value. The reply is
+// cached for the session. Servers not supporting FEAT have no features.
+func (ftp *FTP) Features() (map[string]string, error) {
+	if ftp.features != nil {
+		return ftp.features, nil
+	}
+
+	line, err := ftp.cmd(StatusSystemStatus, "FEAT")
+	if err != nil {
+		if !isNotImplemented(err) {
+			return nil, err
+		}
+		line = ""
+	}
+
+	raw := []string{}
+	for _, l := range strings.Split(line, "\n") {
+		// Feature lines start with a space, unlike the first and last line
+		if !strings.HasPrefix(l, " ") {
+			continue
+		}
+		if l = strings.TrimSpace(l); l != "" {
+			raw = append(raw, l)
+		}
+	}
+
+	ftp.setFeatures(raw)
+	return ftp.features, nil
+}
+
+// setFeatures caches the feature lines of FEAT
+func (ftp *FTP) setFeatures(raw []string) {
+	features := map[string]string{}
+	for _, l := range raw {
+		name, params := l, ""
+		if i := strings.IndexByte(l, ' '); i >= 0 {
+			name, params = l[:i], l[i+1:]
+		}
+		features[strings.ToUpper(name)] = params
+	}
+	ftp.features, ftp.rawFeatures = features, raw
+}
+
+// RawFeatures returns the feature lines of the FEAT reply as sent by the
+// server, without the leading space. Unlike Features, which keeps one entry
+// per name, it has every line, e.g. both "SITE SYMLINK" and "SITE UTIME", so
+// vendor extensions can be detected as is.
+func (ftp *FTP) RawFeatures() ([]string, error) {
+	if _, err := ftp.Features(); err != nil {
+		return nil, err
+	}
+	return ftp.rawFeatures, nil
+}
+
+// HashAlgorithms returns the HASH algorithms advertised by the server, and the
+// one currently selected
+func (ftp *FTP) HashAlgorithms() (algorithms []string, current string, err error) {
+	features, err := ftp.Features()
+	if err != nil {
+		return
+	}
+
+	params, ok := features["HASH"]
+	if !ok {
+		return nil, "", errors.New("server does not support HASH")
+	}
+
+	algorithms, current = parseFeatureChoices(params)
+	if ftp.hashAlgorithm != "" {
+		current = ftp.hashAlgorithm
+	}
+	return
+}
+
+// parseFeatureChoices parses the parameters of a feature offering choices,
+// like "SHA-1*;SHA-256;MD5", the current one being starred
+func parseFeatureChoices(params string) (choices []string, current string) {
+	for _, choice := range strings.Split(params, ";") {
+		choice = strings.TrimSpace(choice)
+		if choice == "" {
+			continue
+		}
+		if strings.HasSuffix(choice, "*") {
+			choice = strings.TrimSuffix(choice, "*")
+			current = choice
+		}
+		choices = append(choices, choice)
+	}
+	return
+}
+
+// Languages returns the languages of the replies advertised by the server
+// (LANG, RFC 2640), and the one currently used
+func (ftp *FTP) Languages() (languages []string, current string, err error) {
+	features, err := ftp.Features()
+	if err != nil {
+		return
+	}
+
+	params, ok := features["LANG"]
+	if !ok {
+		return nil, "", errors.New("server does not support LANG")
+	}
+
+	languages, current = parseFeatureChoices(params)
+	if ftp.language != "" {
+		current = ftp.language
+	}
+	return
+}
+
+// SetLanguage asks the server to send its replies in language, a tag like
+// "fr" or "de-CH" among the Languages. An empty language restores the
+// server's default.
+func (ftp *FTP) SetLanguage(language string) (err error) {
+	if language == "" {
+		_, err = ftp.cmd(StatusOK, "LANG")
+	} else {
+		_, err = ftp.cmd(StatusOK, "LANG %s", language)
+	}
+	if err != nil {
+		return
+	}
+	ftp.language = language
+	return
+}
+
+// SetHashAlgorithm selects the algorithm used by HASH
+func (ftp *FTP) SetHashAlgorithm(algorithm string) error {
+	if _, err := ftp.cmd(StatusOK, "OPTS HASH %s", algorithm); err != nil {
+		return err
+	}
+	ftp.hashAlgorithm = algorithm
+	return nil
+}
+
+// Hash returns the digest of path computed by the server with the selected
+// algorithm, as a hex string
+func (ftp *FTP) Hash(path string) (algorithm string, digest string, err error) {
+	if err = ftp.checkPath(path); err != nil {
+		return
+	}
+
+	var line string
+	if line, err = ftp.cmd(StatusFileStatus, "HASH %s", ftp.remotePath(path)); err != nil {
+		return
+	}
+
+	// 213 SHA-256 0-49 169cd22282da7f147cb491e559e9dd filename
+	fields := strings.SplitN(strings.TrimSpace(line[4:]), " ", 4)
+	if len(fields) < 3 {
+		return "", "", errors.New("invalid HASH reply: " + line)
+	}
+	return fields[0], fields[2], nil
+}
+
+// Checksum returns the digest of path computed by the server with the
+// strongest algorithm supported by both sides
+func (ftp *FTP) Checksum(path string) (algorithm string, digest string, err error) {
+	algorithms, current, err := ftp.HashAlgorithms()
+	if err != nil {
+		return
+	}
+
+	best := ""
+	for _, preferred := range hashPreference {
+		for _, algo := range algorithms {
+			if strings.EqualFold(algo, preferred) {
+				best = algo
+				break
+			}
+		}
+		if best != "" {
+			break
+		}
+	}
+	if best == "" {
+		return "", "", errors.New("no supported HASH algorithm in " + strings.Join(algorithms, ", "))
+	}
+
+	if best != current {
+		if err = ftp.SetHashAlgorithm(best); err != nil {
+			return
+		}
+	}
+
+	return ftp.Hash(path)
+}
+
+// Feature is a server capability checked by Preflight, named after the FEAT
+// line advertising it
+type Feature string
+
+// Features commonly relied on
+const (
+	FeatureMLST Feature = "MLST" // MLSD listings with exact sizes and times
+	FeatureMDTM Feature = "MDTM"
+	FeatureMFMT Feature = "MFMT"
+	FeatureMFF  Feature = "MFF"
+	FeatureSIZE Feature = "SIZE"
+	FeatureREST Feature = "REST STREAM" // restarted transfers
+	FeatureHASH Feature = "HASH"
+	FeatureUTF8 Feature = "UTF8"
+	FeatureTLS  Feature = "AUTH TLS"
+	FeatureEPSV Feature = "EPSV"
+)
+
+// PreflightError lists the features missing for a job. It matches
+// ErrNotSupported.
+type PreflightError struct {
+	Missing []Feature
+}
+
+func (e *PreflightError) Error() string {
+	names := make([]string, len(e.Missing))
+	for i, f := range e.Missing {
+		names[i] = string(f)
+	}
+	if len(names) == 1 {
+		return "server lacks " + names[0]
+	}
+	return "server lacks " + strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+func (e *PreflightError) Unwrap() error {
+	return ErrNotSupported
+}
+
+// Preflight checks that the server advertises every required feature in
+// FEAT, so a job can fail fast before transferring anything. The missing
+// features are returned together as a *PreflightError. A feature with
+// parameters, like "AUTH TLS" or "REST STREAM", needs them advertised too.
+func (ftp *FTP) Preflight(requirements ...Feature) error {
+	features, err := ftp.Features()
+	if err != nil {
+		return err
+	}
+
+	preflight := &PreflightError{}
+	for _, f := range requirements {
+		if !hasFeature(features, f) {
+			preflight.Missing = append(preflight.Missing, f)
+		}
+	}
+	if len(preflight.Missing) > 0 {
+		return preflight
+	}
+	return nil
+}
+
+// hasFeature reports whether the name of f is advertised, with its
+// parameter if it has one
+func hasFeature(features map[string]string, f Feature) bool {
+	name, param, _ := strings.Cut(strings.ToUpper(string(f)), " ")
+	params, ok := features[name]
+	if !ok || param == "" {
+		return ok
+	}
+	for _, p := range strings.FieldsFunc(strings.ToUpper(params), func(r rune) bool {
+		return r == ';' || r == ' ' || r == ','
+	}) {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// pret announces the next transfer command with PRET when the server
+// advertises it. Distributed servers like DrFTPD pick the node serving the
+// transfer from it, and need it before PASV. A failing FEAT is taken as no
+// PRET for the rest of the session, so it never stops the transfers on other
+// servers nor is sent again before each of them.
+func (ftp *FTP) pret(command string, path string) error {
+	if ftp.noPRET {
+		return nil
+	}
+	features, err := ftp.Features()
+	if err != nil {
+		ftp.noPRET = true
+		return nil
+	}
+	if _, ok := features["PRET"]; !ok {
+		return nil
+	}
+
+	if path == "" {
+		_, err = ftp.cmd(StatusOK, "PRET %s", command)
+	} else {
+		_, err = ftp.cmd(StatusOK, "PRET %s %s", command, ftp.remotePath(path))
+	}
+	return err
+}
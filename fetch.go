@@ -0,0 +1,120 @@
+package goftp
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Fetcher gets and puts files addressed by ftp:// and ftps:// URLs, so FTP
+// endpoints can be handled like HTTP ones. Each call uses its own session,
+// logged in with the URL credentials, the Credentials provider or
+// anonymously.
+type Fetcher struct {
+	// TLSConfig is used for ftps:// URLs. If nil, a default config for the
+	// URL host is used.
+	TLSConfig *tls.Config
+
+	// Dial connects to the server. If nil, net.Dial is used.
+	Dial DialFunc
+
+	// Credentials are used for URLs without user information. If nil, the
+	// login is anonymous.
+	Credentials CredentialProvider
+}
+
+// Get retrieves the file at rawurl. The caller must close the returned reader,
+// which also ends the session.
+func (f *Fetcher) Get(rawurl string) (io.ReadCloser, error) {
+	ftp, path, err := f.open(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := ftp.Retr(path, func(r io.Reader) error {
+			_, err := io.Copy(pw, r)
+			return err
+		})
+		if err != nil {
+			ftp.Close()
+		} else {
+			ftp.Quit()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+// Put stores the content of r as the file at rawurl.
+func (f *Fetcher) Put(rawurl string, r io.Reader) error {
+	ftp, path, err := f.open(rawurl)
+	if err != nil {
+		return err
+	}
+
+	if err = ftp.Stor(path, r); err != nil {
+		ftp.Close()
+		return err
+	}
+
+	return ftp.Quit()
+}
+
+// open connects and logs in to the server of rawurl and returns the session
+// and the remote path.
+func (f *Fetcher) open(rawurl string) (*FTP, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != "ftp" && u.Scheme != "ftps" {
+		return nil, "", errors.New("unsupported URL scheme: " + u.Scheme)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "21")
+	}
+
+	dial := f.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+
+	ftp, err := ConnectVia(addr, dial)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if u.Scheme == "ftps" {
+		config := f.TLSConfig
+		if config == nil {
+			config = &tls.Config{ServerName: u.Hostname()}
+		}
+		if err = ftp.AuthTLS(config); err != nil {
+			ftp.Close()
+			return nil, "", err
+		}
+	}
+
+	var provider CredentialProvider = StaticCredentials{"anonymous", "anonymous"}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		provider = StaticCredentials{u.User.Username(), password}
+	} else if f.Credentials != nil {
+		provider = f.Credentials
+	}
+	if err = ftp.LoginWith(provider); err != nil {
+		ftp.Close()
+		return nil, "", err
+	}
+
+	// As with RFC 1738, the path is relative to the login directory
+	return ftp, strings.TrimPrefix(u.Path, "/"), nil
+}
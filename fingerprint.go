@@ -0,0 +1,137 @@
+package goftp
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerSoftware is the FTP server implementation, as far as it can be told
+// from the greeting and SYST
+type ServerSoftware int
+
+// The server implementations recognized by ServerSoftware
+const (
+	SoftwareUnknown ServerSoftware = iota
+	SoftwareVsftpd
+	SoftwareProFTPD
+	SoftwareFileZilla
+	SoftwareIIS
+	SoftwarePureFTPd
+)
+
+var serverSoftwareNames = map[ServerSoftware]string{
+	SoftwareUnknown:   "Unknown",
+	SoftwareVsftpd:    "vsftpd",
+	SoftwareProFTPD:   "ProFTPD",
+	SoftwareFileZilla: "FileZilla Server",
+	SoftwareIIS:       "IIS",
+	SoftwarePureFTPd:  "Pure-FTPd",
+}
+
+func (s ServerSoftware) String() string {
+	return serverSoftwareNames[s]
+}
+
+// softwareSignatures are looked for, lower-cased, in the greeting and SYST
+var softwareSignatures = []struct {
+	signature string
+	software  ServerSoftware
+}{
+	{"vsftpd", SoftwareVsftpd},
+	{"proftpd", SoftwareProFTPD},
+	{"filezilla", SoftwareFileZilla},
+	{"microsoft ftp service", SoftwareIIS},
+	{"pure-ftpd", SoftwarePureFTPd},
+}
+
+// Banner returns the greeting sent by the server on connection
+func (ftp *FTP) Banner() string {
+	return ftp.banner
+}
+
+// ServerSoftware identifies the server from its greeting, or from its SYST
+// reply when the greeting is not telling (FileZilla's default greeting is
+// neutral, but SYST reads "UNIX emulated by FileZilla"). The result is
+// remembered by the session.
+func (ftp *FTP) ServerSoftware() ServerSoftware {
+	if ftp.softwareSet {
+		return ftp.software
+	}
+	if software := matchSoftware(ftp.banner); software != SoftwareUnknown {
+		return software
+	}
+
+	syst, err := ftp.Syst()
+	if err != nil {
+		// Ask again after a network error, not after a refusal
+		if replyCode(err) != 0 {
+			ftp.softwareSet = true
+		}
+		return SoftwareUnknown
+	}
+	ftp.software, ftp.softwareSet = matchSoftware(syst), true
+	return ftp.software
+}
+
+// IdleTimeout returns the inactivity timeout announced in the greeting, e.g.
+// "You will be disconnected after 15 minutes of inactivity" by Pure-FTPd, or
+// false if there was none
+func (ftp *FTP) IdleTimeout() (time.Duration, bool) {
+	return parseIdleTimeout(ftp.banner)
+}
+
+var durationPattern = regexp.MustCompile(`(?i)(\d+)\s*(seconds?|secs?|minutes?|mins?)\b`)
+
+// parseIdleTimeout looks for a duration in a sentence of text about idleness
+func parseIdleTimeout(text string) (time.Duration, bool) {
+	sentences := strings.FieldsFunc(text, func(r rune) bool {
+		return r == '.' || r == '\n'
+	})
+	for _, sentence := range sentences {
+		lower := strings.ToLower(sentence)
+		if !strings.Contains(lower, "idle") && !strings.Contains(lower, "inactiv") {
+			continue
+		}
+		m := durationPattern.FindStringSubmatch(sentence)
+		if m == nil {
+			continue
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n <= 0 {
+			continue
+		}
+		unit := time.Second
+		if strings.HasPrefix(strings.ToLower(m[2]), "min") {
+			unit = time.Minute
+		}
+		return time.Duration(n) * unit, true
+	}
+	return 0, false
+}
+
+func matchSoftware(text string) ServerSoftware {
+	text = strings.ToLower(text)
+	for _, s := range softwareSignatures {
+		if strings.Contains(text, s.signature) {
+			return s.software
+		}
+	}
+	return SoftwareUnknown
+}
+
+// applyQuirks enables the workarounds known for the server announced by the
+// greeting
+func (ftp *FTP) applyQuirks() {
+	switch matchSoftware(ftp.banner) {
+	case SoftwareVsftpd:
+		// vsftpd does not implement MLSD, spare the round trip
+		ftp.noMLSD = true
+	case SoftwareIIS:
+		// Lists in DIR format unless configured otherwise
+		if !ftp.systemKnown {
+			ftp.system = SystemWindowsNT
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package goftp
+
+import (
+	"errors"
+	"strings"
+)
+
+// telnetEncoder frames command lines for the Telnet based control connection:
+// a CR in a pathname is followed by NUL (RFC 2640) and an LF is sent as NUL,
+// the Unix servers' convention, so names cannot end the command early.
+var telnetEncoder = strings.NewReplacer("\r", "\r\x00", "\n", "\x00")
+
+// telnetEncode returns the command line to send for command
+func telnetEncode(command string) string {
+	return telnetEncoder.Replace(command)
+}
+
+// parseQuotedPath returns the pathname quoted in a 257 reply text, undoubling
+// embedded quotes (RFC 959 appendix II)
+func parseQuotedPath(text string) (string, error) {
+	start := strings.IndexByte(text, '"')
+	if start < 0 {
+		return "", errors.New("no quoted path in reply: " + text)
+	}
+
+	var path strings.Builder
+	for i := start + 1; i < len(text); i++ {
+		if text[i] != '"' {
+			path.WriteByte(text[i])
+			continue
+		}
+		if i+1 < len(text) && text[i+1] == '"' {
+			path.WriteByte('"')
+			i++
+			continue
+		}
+		return path.String(), nil
+	}
+	return "", errors.New("unterminated quoted path in reply: " + text)
+}
+
+// listArgument protects a LIST argument starting with a dash from being taken
+// for ls options by the server
+func listArgument(path string) string {
+	if strings.HasPrefix(path, "-") {
+		return "./" + path
+	}
+	return path
+}
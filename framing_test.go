@@ -0,0 +1,52 @@
+package goftp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTelnetEncode(t *testing.T) {
+	for command, want := range map[string]string{
+		"DELE  leading and trailing  ": "DELE  leading and trailing  ",
+		"DELE new\nline":               "DELE new\x00line",
+		"RMD carriage\rreturn":         "RMD carriage\r\x00return",
+		"DELE -dash \"quoted\"":        "DELE -dash \"quoted\"",
+	} {
+		if got := telnetEncode(command); got != want {
+			t.Errorf("%q encoded as %q, want %q", command, got, want)
+		}
+	}
+}
+
+func TestParseQuotedPath(t *testing.T) {
+	for text, want := range map[string]string{
+		`"/home/user" is the current directory`: "/home/user",
+		`"/with ""quotes""" created`:            `/with "quotes"`,
+		`" spaces " ok`:                         " spaces ",
+		`"/a""" ok`:                             `/a"`,
+	} {
+		got, err := parseQuotedPath(text)
+		if err != nil || got != want {
+			t.Errorf("%s parsed as %q, %v, want %q", text, got, err, want)
+		}
+	}
+
+	if _, err := parseQuotedPath("no quotes"); err == nil {
+		t.Error("missing quotes should be an error")
+	}
+}
+
+func TestListEntryNamesPreserved(t *testing.T) {
+	now := time.Now()
+	for line, want := range map[string]string{
+		"type=file;size=1;  leading space":                                    " leading space",
+		"type=file;size=1; trailing space ":                                   "trailing space ",
+		"-rw-r--r--   1 ftp ftp          42 Jun 10  1994  two leading spaces": " two leading spaces",
+		"-rw-r--r--   1 ftp ftp          42 Jun 10  1994 -dash":               "-dash",
+	} {
+		e, err := parseListLine(line, now, time.UTC)
+		if err != nil || e.Name != want {
+			t.Errorf("%q: got %+v, %v, want name %q", line, e, err, want)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package goftp
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
 	"net"
@@ -33,6 +35,33 @@ var errUnsupportedListLine = errors.New("unsupported LIST line")
 var errUnknownListEntryType = errors.New("unknown entry type")
 var errUnsupportedListDate = errors.New("unsupported LIST date")
 
+// ErrRestartNotSupported is returned when the server rejects REST or restarts
+// at another offset than requested. The transfer has to start from scratch.
+var ErrRestartNotSupported = errors.New("restart not supported")
+
+// ErrRestartASCII is returned by Rest in ASCII mode, where the server's byte
+// offsets do not match local file offsets because of line ending conversion.
+// It matches ErrRestartNotSupported.
+var ErrRestartASCII = fmt.Errorf("%w in ASCII mode", ErrRestartNotSupported)
+
+// ErrAuthTimeout is returned by Login when the server does not answer a
+// login command within the auth timeout
+var ErrAuthTimeout = errors.New("timeout waiting for login reply")
+
+// ErrAuthFailed is matched by errors.Is when the server rejects the login
+// with a 530 reply, as opposed to a network failure
+var ErrAuthFailed = errors.New("authentication failed")
+
+// ErrTLSRequired is matched by errors.Is when a session requiring TLS would
+// proceed in plaintext, see SetRequireTLS and ConnectTLS
+var ErrTLSRequired = errors.New("TLS required")
+
+// ErrPassivePort is matched by errors.Is when the server offers a passive
+// port outside the range set with SetPassivePortRange
+var ErrPassivePort = errors.New("passive port out of range")
+
+var reRestOffset = regexp.MustCompile(`\d+`)
+
 type Response struct {
 	conn   net.Conn
 	closed bool
@@ -42,11 +71,55 @@ type Response struct {
 type FTP struct {
 	conn net.Conn
 
-	addr string
+	addr   string
+	dial   DialFunc        // nil for a net.Dialer
+	runCtx context.Context // the context of RunContext, if running
 
 	debug     bool
 	tlsconfig *tls.Config
 
+	pathMapper PathMapper
+	location   *time.Location
+
+	lenientList bool
+	listSpill   int
+
+	authTimeout time.Duration
+	requireTLS  bool
+
+	passiveMin, passiveMax int
+
+	dataMode             DataMode
+	activeMin, activeMax int
+	activeAddr           string
+
+	banner      string
+	noMLSD      bool
+	noSiteRmdir bool
+	noALLO      bool
+	noPRET      bool
+	system      ServerSystem
+	systemKnown bool
+	software    ServerSoftware
+	softwareSet bool
+	features    map[string]string
+	rawFeatures []string
+
+	hashAlgorithm string
+	language      string
+	transferType  TypeCode
+
+	readOnly      bool
+	allocate      bool
+	mappedUploads bool
+
+	lastTransfer *TransferStats
+	abort        abortState
+	deadline     deadlineState
+
+	guard    *Guard
+	guardCwd string
+
 	reader *bufio.Reader
 	writer *bufio.Writer
 }
@@ -66,26 +139,41 @@ type (
 
 type EntryType int
 type Entry struct {
-	Name   string
-	Target string // target of symbolic link
-	Type   EntryType
-	Size   uint64
-	Time   time.Time
+	Name   string    `json:"name"`
+	Target string    `json:"target,omitempty"` // target of symbolic link
+	Type   EntryType `json:"type"`
+	Size   uint64    `json:"size"`
+	Time   time.Time `json:"time"`
+	Perm   string    `json:"perm,omitempty"`  // permissions as listed, e.g. "-rw-r--r--" or the MLSD perm fact
+	Owner  string    `json:"owner,omitempty"` // owner, if listed
+	Group  string    `json:"group,omitempty"` // group, if listed
+	Raw    string    `json:"raw,omitempty"`   // listing line the entry was parsed from
 }
 
+// PathMapper rewrites a remote path before it is sent to the server
+type PathMapper func(path string) string
+
 type parseFunc func(string, time.Time, *time.Location) (*Entry, error)
 
 func parseLine(line string) (perm string, t string, filename string) {
-	for _, v := range strings.Split(line, ";") {
-		v2 := strings.Split(v, "=")
+	// facts; name
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return
+	}
+	filename = strings.TrimRight(line[i+1:], "\r\n")
+
+	for _, v := range strings.Split(line[:i], ";") {
+		v2 := strings.SplitN(v, "=", 2)
+		if len(v2) < 2 {
+			continue
+		}
 
-		switch v2[0] {
+		switch strings.ToLower(v2[0]) {
 		case "perm":
 			perm = v2[1]
 		case "type":
 			t = v2[1]
-		default:
-			filename = v[1 : len(v)-2]
 		}
 	}
 	return
@@ -132,6 +220,40 @@ func (ftp *FTP) Walk(path string, walkFn WalkFunc) (err error) {
 	return
 }
 
+// SetPathMapper sets a mapper applied to every remote path passed to the
+// session's commands. A nil mapper sends paths unchanged.
+func (ftp *FTP) SetPathMapper(mapper PathMapper) {
+	ftp.pathMapper = mapper
+}
+
+// SetLocation sets the time zone the server lists modification times in.
+// It is used to parse the Entry times returned by List; the default is UTC.
+func (ftp *FTP) SetLocation(loc *time.Location) {
+	ftp.location = loc
+}
+
+// SetLenientList makes List keep the lines it cannot parse, as entries with
+// only a name guessed from the line, instead of dropping them
+func (ftp *FTP) SetLenientList(lenient bool) {
+	ftp.lenientList = lenient
+}
+
+// SetPassivePortRange makes Pasv reject the ports outside [min, max], e.g.
+// the ports an egress firewall lets through, with an error matching
+// ErrPassivePort instead of a data connection timing out. Zero for both
+// accepts any port, the default.
+func (ftp *FTP) SetPassivePortRange(min, max int) {
+	ftp.passiveMin, ftp.passiveMax = min, max
+}
+
+// remotePath returns path as it should be sent to the server
+func (ftp *FTP) remotePath(path string) string {
+	if ftp.pathMapper == nil {
+		return path
+	}
+	return ftp.pathMapper(path)
+}
+
 // Quit sends quit to the server and close the connection. No need to Close after this.
 func (ftp *FTP) Quit() (err error) {
 	if _, err := ftp.cmd(StatusConnectionClosing, "QUIT"); err != nil {
@@ -172,7 +294,7 @@ func (ftp *FTP) RawCmd(command string, args ...interface{}) (code int, line stri
 }
 
 // private function to send command and compare return code with expects
-func (ftp *FTP) cmd(expects string, command string, args ...interface{}) (line string, err error) {
+func (ftp *FTP) cmd(expects StatusCode, command string, args ...interface{}) (line string, err error) {
 	if err = ftp.send(command, args...); err != nil {
 		return
 	}
@@ -181,7 +303,7 @@ func (ftp *FTP) cmd(expects string, command string, args ...interface{}) (line s
 		return
 	}
 
-	if !strings.HasPrefix(line, expects) {
+	if parseReplyCode(line) != expects {
 		err = errors.New(line)
 		return
 	}
@@ -189,28 +311,75 @@ func (ftp *FTP) cmd(expects string, command string, args ...interface{}) (line s
 	return
 }
 
-// Rename file on the remote host
+// RenameError is the failure of Rename. Command tells which half failed:
+// "RNFR", the source was not accepted, or "RNTO", the source still exists
+// under its name.
+type RenameError struct {
+	Command string
+	From    string
+	To      string
+	Err     error
+}
+
+func (e *RenameError) Error() string {
+	return e.Command + " failed renaming " + e.From + " to " + e.To + ": " + strings.TrimSpace(e.Err.Error())
+}
+
+func (e *RenameError) Unwrap() error {
+	return e.Err
+}
+
+// Rename file on the remote host. Some servers forget the RNFR on a transient
+// error, so when RNTO fails with a 4xx reply or a bad sequence, the pair is
+// sent once more. Failed commands are returned as a *RenameError.
 func (ftp *FTP) Rename(from string, to string) (err error) {
-	if _, err = ftp.cmd(StatusActionPending, "RNFR %s", from); err != nil {
+	if err = ftp.checkPath(from); err != nil {
 		return
 	}
-
-	if _, err = ftp.cmd(StatusActionOK, "RNTO %s", to); err != nil {
+	if err = ftp.checkPath(to); err != nil {
 		return
 	}
 
-	return
+	for attempt := 0; ; attempt++ {
+		err = ftp.rename(from, to)
+		rerr, ok := err.(*RenameError)
+		if !ok || rerr.Command != "RNTO" || attempt > 0 {
+			return
+		}
+		if code := replyCode(rerr.Err); code != StatusBadCommandSequence && !code.IsTransientNegative() {
+			return
+		}
+	}
+}
+
+// rename sends the RNFR and RNTO pair once
+func (ftp *FTP) rename(from string, to string) error {
+	if _, err := ftp.cmd(StatusActionPending, "RNFR %s", ftp.remotePath(from)); err != nil {
+		return &RenameError{Command: "RNFR", From: from, To: to, Err: err}
+	}
+
+	if _, err := ftp.cmd(StatusActionOK, "RNTO %s", ftp.remotePath(to)); err != nil {
+		return &RenameError{Command: "RNTO", From: from, To: to, Err: err}
+	}
+
+	return nil
 }
 
 // Mkd makes a directory on the remote host
 func (ftp *FTP) Mkd(path string) error {
-	_, err := ftp.cmd(StatusPathCreated, "MKD %s", path)
+	if err := ftp.checkPath(path); err != nil {
+		return err
+	}
+	_, err := ftp.cmd(StatusPathCreated, "MKD %s", ftp.remotePath(path))
 	return err
 }
 
 // Rmd remove directory
 func (ftp *FTP) Rmd(path string) (err error) {
-	_, err = ftp.cmd(StatusActionOK, "RMD %s", path)
+	if err = ftp.checkPath(path); err != nil {
+		return
+	}
+	_, err = ftp.cmd(StatusActionOK, "RMD %s", ftp.remotePath(path))
 	return
 }
 
@@ -221,21 +390,27 @@ func (ftp *FTP) Pwd() (path string, err error) {
 		return
 	}
 
-	res := RePwdPath.FindAllStringSubmatch(line[4:], -1)
-
-	path = res[0][1]
+	path, err = parseQuotedPath(line[4:])
 	return
 }
 
 // Cwd changes current working directory on remote host to path
 func (ftp *FTP) Cwd(path string) (err error) {
-	_, err = ftp.cmd(StatusActionOK, "CWD %s", path)
+	if err = ftp.checkPath(path); err != nil {
+		return
+	}
+	_, err = ftp.cmd(StatusActionOK, "CWD %s", ftp.remotePath(path))
+	ftp.guardCwd = ""
 	return
 }
 
 // Dele deletes path on remote host
 func (ftp *FTP) Dele(path string) (err error) {
-	if err = ftp.send("DELE %s", path); err != nil {
+	if err = ftp.checkPath(path); err != nil {
+		return
+	}
+
+	if err = ftp.send("DELE %s", ftp.remotePath(path)); err != nil {
 		return
 	}
 
@@ -244,7 +419,7 @@ func (ftp *FTP) Dele(path string) (err error) {
 		return
 	}
 
-	if !strings.HasPrefix(line, StatusActionOK) {
+	if parseReplyCode(line) != StatusActionOK {
 		return errors.New(line)
 	}
 
@@ -253,7 +428,7 @@ func (ftp *FTP) Dele(path string) (err error) {
 
 // AuthTLS secures the ftp connection by using TLS
 func (ftp *FTP) AuthTLS(config *tls.Config) error {
-	if _, err := ftp.cmd("234", "AUTH TLS"); err != nil {
+	if _, err := ftp.cmd(StatusSecurityExchangeOK, "AUTH TLS"); err != nil {
 		return err
 	}
 
@@ -290,8 +465,11 @@ func (ftp *FTP) ReadAndDiscard() (int, error) {
 
 // Type changes transfer type.
 func (ftp *FTP) Type(t TypeCode) error {
-	_, err := ftp.cmd(StatusOK, "TYPE %s", t)
-	return err
+	if _, err := ftp.cmd(StatusOK, "TYPE %s", t); err != nil {
+		return err
+	}
+	ftp.transferType = t
+	return nil
 }
 
 // TypeCode for the representation types
@@ -390,6 +568,11 @@ func (ftp *FTP) send(command string, arguments ...interface{}) error {
 	}
 
 	command = fmt.Sprintf(command, arguments...)
+	if ftp.readOnly && isMutating(command) {
+		return ErrReadOnly
+	}
+
+	command = telnetEncode(command)
 	command += "\r\n"
 
 	if _, err := ftp.writer.WriteString(command); err != nil {
@@ -412,7 +595,7 @@ func (ftp *FTP) Pasv() (port int, err error) {
 			doneChan <- 1
 		}()
 		var line string
-		if line, err = ftp.cmd("227", "PASV"); err != nil {
+		if line, err = ftp.cmd(StatusPassiveMode, "PASV"); err != nil {
 			return
 		}
 		re := regexp.MustCompile(`\((.*)\)`)
@@ -431,6 +614,11 @@ func (ftp *FTP) Pasv() (port int, err error) {
 
 		port = l1<<8 + l2
 
+		if ftp.passiveMax > 0 && (port < ftp.passiveMin || port > ftp.passiveMax) {
+			err = fmt.Errorf("%w: server offered port %d, allowed are %d-%d; configure the server's passive ports within the range",
+				ErrPassivePort, port, ftp.passiveMin, ftp.passiveMax)
+		}
+
 		return
 	}()
 
@@ -447,161 +635,197 @@ func (ftp *FTP) Pasv() (port int, err error) {
 
 // open new data connection
 func (ftp *FTP) newConnection(port int) (conn net.Conn, err error) {
-	addr := fmt.Sprintf("%s:%d", strings.Split(ftp.addr, ":")[0], port)
+	host, _, err := net.SplitHostPort(ftp.addr)
+	if err != nil {
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 
 	if ftp.debug {
 		log.Printf("Connecting to %s\n", addr)
 	}
 
-	if conn, err = net.Dial("tcp", addr); err != nil {
+	// Under RunContext, a data port which never answers does not outlive
+	// the context
+	ctx := ftp.runCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if conn, err = dialContext(ctx, ftp.dial, "tcp", addr); err != nil {
 		return
 	}
 
+	return ftp.secureData(conn), nil
+}
+
+// secureData wraps a data connection in TLS if the control connection is.
+// The client is the TLS client in active mode too (RFC 4217).
+func (ftp *FTP) secureData(conn net.Conn) net.Conn {
 	if ftp.tlsconfig != nil {
-		conn = tls.Client(conn, ftp.tlsconfig)
+		return tls.Client(conn, ftp.tlsconfig)
 	}
-
-	return
+	return conn
 }
 
 // Stor uploads file to remote host path, from r
 func (ftp *FTP) Stor(path string, r io.Reader) error {
-	if err := ftp.Type(TypeImage); err != nil {
-		return err
-	}
-
-	port, err := ftp.Pasv()
-	if err != nil {
-		return err
-	}
-
-	if err := ftp.send("STOR %s", path); err != nil {
-		return err
-	}
+	return ftp.stor(path, r, 0, false)
+}
 
-	pconn, err := ftp.newConnection(port)
-	if err != nil {
-		return err
+// Rest sets the offset the next transfer restarts at. If the server rejects
+// it, or its reply states another offset, the error wraps
+// ErrRestartNotSupported. REST is refused in ASCII mode with ErrRestartASCII.
+func (ftp *FTP) Rest(offset uint64) error {
+	if ftp.transferType == TypeASCII {
+		return ErrRestartASCII
 	}
-	defer pconn.Close()
 
-	line, err := ftp.receive()
+	line, err := ftp.cmd(StatusActionPending, "REST %d", offset)
 	if err != nil {
-		return err
-	}
-
-	if _, err := io.Copy(pconn, r); err != nil {
-		fmt.Println(7)
-		return err
+		if replyCode(err) == 0 {
+			return err
+		}
+		return fmt.Errorf("%w: %s", ErrRestartNotSupported, strings.TrimSpace(err.Error()))
 	}
-	pconn.Close()
 
-	if line, err = ftp.receive(); err != nil {
-		fmt.Println(8)
-		return err
+	// Some servers reply with the effective restart point
+	if len(line) > 4 {
+		if n := reRestOffset.FindString(line[4:]); n != "" {
+			if effective, err := strconv.ParseUint(n, 10, 64); err == nil && effective != offset {
+				return fmt.Errorf("%w: server restarts at %d instead of %d", ErrRestartNotSupported, effective, offset)
+			}
+		}
 	}
 
-	if !strings.HasPrefix(line, StatusClosingDataConnection) {
-		err := errors.New(line)
-		fmt.Println(9)
-		return err
-	}
 	return nil
 }
 
+// RetrFrom retrieves file from remote host at path like Retr, restarting the
+// transfer at offset. REST is not sent for a zero offset.
 func (ftp *FTP) RetrFrom(path string, offset uint64, retrFn RetrFunc) error {
-	if err := ftp.Type(TypeImage); err != nil {
-		return err
-	}
+	return ftp.retr(path, retrFn, offset, true)
+}
 
-	port, err := ftp.Pasv()
-	if err != nil {
-		return err
-	}
+// StorFrom uploads to remote host path like Stor, restarting the transfer at
+// offset. r must start at offset. REST is not sent for a zero offset.
+func (ftp *FTP) StorFrom(path string, r io.Reader, offset uint64) error {
+	return ftp.stor(path, r, offset, true)
+}
 
-	if err := ftp.send("REST %d", offset); err != nil {
+func (ftp *FTP) retr(path string, retrFn RetrFunc, offset uint64, restart bool) error {
+	limit, err := ftp.guardDownload(path)
+	if err != nil {
 		return err
 	}
 
-	if err := ftp.send("RETR %s", path); err != nil {
+	if err := ftp.Type(TypeImage); err != nil {
 		return err
 	}
 
-	var pconn net.Conn
-	if pconn, err = ftp.newConnection(port); err != nil {
+	pconn, err := ftp.openDataConn("RETR", path, offset, restart)
+	if err != nil {
 		return err
 	}
 	defer pconn.Close()
 
-	var line string
-	if line, err = ftp.receiveNoDiscard(); err != nil {
-		return err
+	var data io.Reader = pconn
+	if limit > 0 {
+		data = &guardedReader{r: pconn, remaining: limit, path: path}
 	}
-
-	if err = retrFn(pconn); err != nil {
-		return err
+	if err = retrFn(data); err != nil {
+		return ftp.failTransfer(pconn, err)
 	}
 
 	pconn.Close()
 
-	if line, err = ftp.receive(); err != nil {
-		return err
-	}
+	return ftp.endTransfer(ftp.transferComplete(), true)
+}
 
-	if !strings.HasPrefix(line, StatusClosingDataConnection) {
-		err = errors.New(line)
+func (ftp *FTP) stor(path string, r io.Reader, offset uint64, restart bool) error {
+	if err := ftp.checkWritable(); err != nil {
 		return err
 	}
-
-	return nil
-}
-
-func (ftp *FTP) StorFrom(path string, r io.Reader, offset uint64) error {
-	if err := ftp.Type(TypeImage); err != nil {
+	if err := ftp.allocateFor(r, offset); err != nil {
 		return err
 	}
 
-	port, err := ftp.Pasv()
+	r, err := ftp.guardUpload(path, r)
 	if err != nil {
 		return err
 	}
 
-	if err := ftp.send("REST %d", offset); err != nil {
+	if err := ftp.Type(TypeImage); err != nil {
 		return err
 	}
 
-	if err := ftp.send("STOR %s", path); err != nil {
-		return err
-	}
-	pconn, err := ftp.newConnection(port)
+	pconn, err := ftp.openDataConn("STOR", path, offset, restart)
 	if err != nil {
 		return err
 	}
 	defer pconn.Close()
 
-	line, err := ftp.receive()
-	if err != nil {
-		return err
+	if _, err = io.Copy(pconn, r); err != nil {
+		return ftp.failTransfer(pconn, err)
 	}
 
-	if _, err := io.Copy(pconn, r); err != nil {
-		fmt.Println(7)
-		return err
-	}
+	// The server only completes the upload once the data connection is closed
 	pconn.Close()
 
-	if line, err = ftp.receive(); err != nil {
-		fmt.Println(8)
-		return err
+	return ftp.endTransfer(ftp.transferComplete(), true)
+}
+
+// SetAllocate makes uploads reserve their size on the server with ALLO
+// before STOR, as required by some mainframe and quota enforcing servers. The
+// size is known for sources like *os.File, *bytes.Reader or MappedFile, other
+// sources are uploaded without ALLO. A server not implementing ALLO is not
+// asked again.
+func (ftp *FTP) SetAllocate(allocate bool) {
+	ftp.allocate = allocate
+}
+
+// allocateFor sends ALLO for the upload of r at offset, if enabled
+func (ftp *FTP) allocateFor(r io.Reader, offset uint64) error {
+	if !ftp.allocate || ftp.noALLO {
+		return nil
+	}
+	size, ok := readerSize(r)
+	if !ok {
+		return nil
 	}
 
-	if !strings.HasPrefix(line, StatusClosingDataConnection) {
+	line, err := ftp.cmd(StatusOK, "ALLO %d", int64(offset)+size)
+	switch {
+	case err == nil, parseReplyCode(line) == StatusSuperfluous:
 		return nil
+	case isNotImplemented(err):
+		ftp.noALLO = true
+		return nil
+	}
+	return err
+}
+
+// transferComplete reads the reply ending a transfer, which some servers send
+// before the data connection is closed and others after. Any positive
+// completion reply (226, or 250 for some servers) is a success.
+func (ftp *FTP) transferComplete() error {
+	line, err := ftp.receive()
+	if ftp.lastTransfer != nil {
+		ftp.lastTransfer.Duration = time.Since(ftp.lastTransfer.Start)
+		ftp.lastTransfer.Reply = parseReplyCode(line)
 	}
+	if err != nil {
+		return err
+	}
+
+	if !parseReplyCode(line).IsPositiveCompletion() {
+		return errors.New(line)
+	}
+
 	return nil
 }
 
-// Syst returns the system type of the remote host
+// Syst returns the system type of the remote host. The type is also
+// remembered by the session, see System.
 func (ftp *FTP) Syst() (line string, err error) {
 	if err := ftp.send("SYST"); err != nil {
 		return "", err
@@ -609,12 +833,14 @@ func (ftp *FTP) Syst() (line string, err error) {
 	if line, err = ftp.receive(); err != nil {
 		return
 	}
-	if !strings.HasPrefix(line, StatusSystemType) {
+	if parseReplyCode(line) != StatusSystemType {
 		err = errors.New(line)
 		return
 	}
 
-	return strings.SplitN(strings.TrimSpace(line), " ", 2)[1], nil
+	line = strings.TrimSpace(line[3:])
+	ftp.system, ftp.systemKnown = parseSystem(line), true
+	return line, nil
 }
 
 // System types from Syst
@@ -623,14 +849,12 @@ var (
 	SystemTypeWindowsNT = "Windows_NT"
 )
 
-var reSystStatus = map[string]*regexp.Regexp{
-	SystemTypeUnixL8:    regexp.MustCompile(""),
-	SystemTypeWindowsNT: regexp.MustCompile(""),
-}
-
 // Stat gets the status of path from the remote host
 func (ftp *FTP) Stat(path string) ([]string, error) {
-	if err := ftp.send("STAT %s", path); err != nil {
+	if err := ftp.checkPath(path); err != nil {
+		return nil, err
+	}
+	if err := ftp.send("STAT %s", ftp.remotePath(path)); err != nil {
 		return nil, err
 	}
 
@@ -638,17 +862,16 @@ func (ftp *FTP) Stat(path string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	if !strings.HasPrefix(stat, StatusFileStatus) &&
-		!strings.HasPrefix(stat, StatusDirectoryStatus) &&
-		!strings.HasPrefix(stat, StatusSystemStatus) {
-		return nil, errors.New(stat)
-	}
-	if strings.HasPrefix(stat, StatusSystemStatus) {
+	switch parseReplyCode(stat) {
+	case StatusFileStatus, StatusDirectoryStatus:
+	case StatusSystemStatus:
 		return strings.Split(stat, "\n"), nil
+	default:
+		return nil, errors.New(stat)
 	}
 	lines := []string{}
 	for _, line := range strings.Split(stat, "\n") {
-		if strings.HasPrefix(line, StatusFileStatus) {
+		if parseReplyCode(line) == StatusFileStatus {
 			continue
 		}
 		//fmt.Printf("%v\n", re.FindAllStringSubmatch(line, -1))
@@ -666,128 +889,193 @@ func (ftp *FTP) Stat(path string) ([]string, error) {
 
 // Retr retrieves file from remote host at path, using retrFn to read from the remote file.
 func (ftp *FTP) Retr(path string, retrFn RetrFunc) (s string, err error) {
-	if err = ftp.Type(TypeImage); err != nil {
-		return
-	}
+	err = ftp.retr(path, retrFn, 0, false)
+	return
+}
 
-	var port int
-	if port, err = ftp.Pasv(); err != nil {
-		return
+// RetrTo retrieves file from remote host at path into w and returns the
+// number of bytes written. The content is also written to every hash, e.g. to
+// verify a checksum without a second pass.
+func (ftp *FTP) RetrTo(path string, w io.Writer, hashes ...hash.Hash) (n int64, err error) {
+	writers := []io.Writer{w}
+	for _, h := range hashes {
+		writers = append(writers, h)
 	}
+	dst := io.MultiWriter(writers...)
 
-	if err = ftp.send("RETR %s", path); err != nil {
+	_, err = ftp.Retr(path, func(r io.Reader) (err error) {
+		n, err = io.Copy(dst, r)
 		return
-	}
+	})
+	return
+}
 
+// List lists the path (or current directory)
+func (ftp *FTP) List(path string) (entries []*Entry, err error) {
 	var pconn net.Conn
-	if pconn, err = ftp.newConnection(port); err != nil {
+	var mlsd bool
+	if pconn, mlsd, err = ftp.openListing(path); err != nil {
 		return
 	}
 	defer pconn.Close()
 
-	var line string
-	if line, err = ftp.receiveNoDiscard(); err != nil {
-		return
+	parser := ftp.listLineParser()
+	if mlsd {
+		parser = parseRFC3659ListLine
 	}
 
-	if err = retrFn(pconn); err != nil {
-		return
+	loc := ftp.location
+	if loc == nil {
+		loc = time.UTC
 	}
 
-	pconn.Close()
-
-	if line, err = ftp.receive(); err != nil {
-		return
+	scanner := bufio.NewScanner(pconn)
+	now := time.Now()
+	for scanner.Scan() {
+		entry, err := parser(scanner.Text(), now, loc)
+		if err != nil && ftp.lenientList {
+			entry, err = parseLenientListLine(scanner.Text())
+		}
+		if err == nil {
+			entry.Raw = scanner.Text()
+			entries = append(entries, entry)
+		}
 	}
-
-	if !strings.HasPrefix(line, StatusClosingDataConnection) {
-		err = errors.New(line)
-		return
+	if err = scanner.Err(); err != nil {
+		return nil, ftp.failTransfer(pconn, err)
 	}
 
+	// Must close for vsftp tlsed conenction otherwise does not receive connection
+	pconn.Close()
+
+	err = ftp.endTransfer(ftp.transferComplete(), true)
 	return
 }
 
-/*func GetFilesList(path string) (files []string, err error) {
-
-}*/
+// openListing opens the data connection of a listing of path, using MLSD if
+// the server accepts it and LIST otherwise. mlsd tells which one is used.
+func (ftp *FTP) openListing(path string) (pconn net.Conn, mlsd bool, err error) {
+	if path != "" {
+		if err = ftp.checkPath(path); err != nil {
+			return
+		}
+	}
 
-// List lists the path (or current directory)
-func (ftp *FTP) List(path string) (entries []*Entry, err error) {
 	if err = ftp.Type(TypeASCII); err != nil {
 		return
 	}
 
-	var port int
-	if port, err = ftp.Pasv(); err != nil {
+	if ftp.noMLSD {
+		ftp.detectSystem()
+		pconn, err = ftp.openDataConn("LIST", listArgument(path), 0, false)
 		return
 	}
 
-	// check if MLSD works
-	if err = ftp.send("MLSD %s", path); err != nil {
+	if pconn, err = ftp.openDataConn("MLSD", path, 0, false); err == nil {
+		return pconn, true, nil
 	}
 
-	var pconn net.Conn
-	if pconn, err = ftp.newConnection(port); err != nil {
+	// Only a rejection of MLSD itself (500, 502, 550...) is worth a retry,
+	// on a new passive port as the previous one may be closed already
+	if replyCode(err) == 0 {
 		return
 	}
-	defer pconn.Close()
+	if ftp.debug {
+		log.Printf("MLSD failed, falling back to LIST: %s", strings.TrimSpace(err.Error()))
+	}
 
-	var line string
-	if line, err = ftp.receiveNoDiscard(); err != nil {
+	ftp.detectSystem()
+	pconn, err = ftp.openDataConn("LIST", listArgument(path), 0, false)
+	return
+}
+
+// openDataConn negotiates a passive data connection, connects it and sends
+// command with the remote path, preceded by REST offset if restart is set.
+// The connection is returned once the server replied with a positive
+// preliminary reply; any other reply is the error.
+func (ftp *FTP) openDataConn(command string, path string, offset uint64, restart bool) (pconn net.Conn, err error) {
+	if err = ftp.pret(command, path); err != nil {
 		return
 	}
 
-	var parser parseFunc
-	parser = parseRFC3659ListLine
-
-	if !strings.HasPrefix(line, StatusFileOK) {
-		// MLSD failed, lets try LIST
-		parser = parseListLine
-		if err = ftp.send("LIST %s", path); err != nil {
+	// In active mode, the server connects once it accepted the command
+	var listener net.Listener
+	if ftp.dataMode == ActiveMode {
+		if listener, err = ftp.listenActive(); err != nil {
+			return
+		}
+		defer listener.Close()
+	} else {
+		var port int
+		if port, err = ftp.Pasv(); err != nil {
 			return
 		}
 
-		if line, err = ftp.receiveNoDiscard(); err != nil {
+		if pconn, err = ftp.newConnection(port); err != nil {
 			return
 		}
 	}
+	closeData := func() {
+		if pconn != nil {
+			pconn.Close()
+		}
+	}
 
-	// reader := bufio.NewReader(pconn)
-
-	// for {
-	// 	line, err = reader.ReadString('\n')
-	// 	if err == io.EOF {
-	// 		break
-	// 	} else if err != nil {
-	// 		return
-	// 	}
-
-	// 	files = append(files, string(line))
-	// }
-	// Must close for vsftp tlsed conenction otherwise does not receive connection
-	scanner := bufio.NewScanner(pconn)
-	now := time.Now()
-	for scanner.Scan() {
-		entry, err := parser(scanner.Text(), now, time.UTC)
-		if err == nil {
-			entries = append(entries, entry)
+	// Some servers reject REST 0, which is the default anyway
+	restart = restart && offset > 0
+	if restart {
+		if err = ftp.Rest(offset); err != nil {
+			closeData()
+			return nil, err
 		}
 	}
-	if err := scanner.Err(); err != nil {
+
+	if path == "" {
+		err = ftp.send("%s", command)
+	} else {
+		err = ftp.send("%s %s", command, ftp.remotePath(path))
+	}
+	if err != nil {
+		closeData()
 		return nil, err
 	}
-	return
-	if line, err = ftp.receive(); err != nil {
-		return
+
+	var line string
+	if line, err = ftp.receiveNoDiscard(); err != nil {
+		closeData()
+		return nil, err
 	}
 
-	if !strings.HasPrefix(line, StatusClosingDataConnection) {
-		err = errors.New(line)
-		return
+	if !parseReplyCode(line).IsPositivePreliminary() {
+		closeData()
+		return nil, errors.New(line)
 	}
 
-	return
+	if listener != nil {
+		if pconn, err = ftp.acceptActive(listener); err != nil {
+			return nil, err
+		}
+	}
+
+	// Complete the TLS handshake now, as an empty transfer would skip it
+	if tlsConn, ok := pconn.(*tls.Conn); ok {
+		if err = tlsConn.Handshake(); err != nil {
+			pconn.Close()
+			return nil, err
+		}
+	}
+
+	ftp.lastTransfer = &TransferStats{
+		Command:    command,
+		Path:       path,
+		Restarted:  restart,
+		Offset:     offset,
+		Start:      time.Now(),
+		LocalAddr:  pconn.LocalAddr(),
+		RemoteAddr: pconn.RemoteAddr(),
+	}
+	ftp.startTransfer(pconn)
+	return &statsConn{Conn: pconn, stats: ftp.lastTransfer, onClose: ftp.transferClosed}, nil
 }
 
 /*
@@ -826,68 +1114,158 @@ func (ftp *FTP) SmartLogin(username string, password string) (err error) {
 
 // Login to the server with provided username and password.
 // Typical default may be ("anonymous","").
+// A rejected login returns an error matching ErrAuthFailed.
 func (ftp *FTP) Login(username string, password string) (err error) {
-	if _, err = ftp.cmd("331", "USER %s", username); err != nil {
-		if strings.HasPrefix(err.Error(), "230") {
+	if ftp.requireTLS && ftp.tlsconfig == nil {
+		return fmt.Errorf("%w: refusing to send credentials in plaintext", ErrTLSRequired)
+	}
+
+	if _, err = ftp.authCmd(StatusUserOK, "USER %s", username); err != nil {
+		if replyCode(err) == StatusLoggedIn {
 			// Ok, probably anonymous server
 			// but login was fine, so return no error
-			err = nil
-		} else {
-			return
+			return nil
 		}
+		return
 	}
 
-	if _, err = ftp.cmd("230", "PASS %s", password); err != nil {
+	if _, err = ftp.authCmd(StatusLoggedIn, "PASS %s", password); err != nil {
 		return
 	}
 
 	return
 }
 
-// Connect to server at addr (format "host:port"). debug is OFF
-func Connect(addr string) (*FTP, error) {
-	var err error
-	var conn net.Conn
+// SetRequireTLS makes Login fail with ErrTLSRequired instead of sending the
+// credentials before AuthTLS succeeded, protecting against a configuration
+// silently falling back to plaintext
+func (ftp *FTP) SetRequireTLS(require bool) {
+	ftp.requireTLS = require
+}
 
-	if conn, err = net.Dial("tcp", addr); err != nil {
-		return nil, err
+// SetAuthTimeout bounds each step of Login. A server which does not answer
+// USER or PASS within d makes Login fail with ErrAuthTimeout. Zero, the
+// default, waits forever.
+func (ftp *FTP) SetAuthTimeout(d time.Duration) {
+	ftp.authTimeout = d
+}
+
+// authCmd is cmd bounded by the auth timeout
+func (ftp *FTP) authCmd(expects StatusCode, command string, args ...interface{}) (line string, err error) {
+	if ftp.authTimeout <= 0 {
+		line, err = ftp.cmd(expects, command, args...)
+		return line, authFailure(err)
 	}
 
-	writer := bufio.NewWriter(conn)
-	reader := bufio.NewReader(conn)
+	if err = ftp.setDeadline(time.Now().Add(ftp.authTimeout)); err != nil {
+		return
+	}
+	defer ftp.setDeadline(time.Time{})
+
+	line, err = ftp.cmd(expects, command, args...)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		err = ErrAuthTimeout
+	}
+	return line, authFailure(err)
+}
 
-	//reader.ReadString('\n')
-	object := &FTP{conn: conn, addr: addr, reader: reader, writer: writer, debug: false}
-	object.receive()
+// authFailure wraps a 530 reply to a login command as an authError
+func authFailure(err error) error {
+	if err != nil && replyCode(err) == StatusNotLoggedIn {
+		return &authError{reply: err}
+	}
+	return err
+}
+
+// authError is a rejection of the credentials by the server. Its message is
+// the reply.
+type authError struct {
+	reply error
+}
+
+func (e *authError) Error() string {
+	return e.reply.Error()
+}
+
+func (e *authError) Unwrap() error {
+	return e.reply
+}
+
+func (e *authError) Is(target error) bool {
+	return target == ErrAuthFailed
+}
 
-	return object, nil
+// Connect to server at addr (format "host:port"). debug is OFF
+func Connect(addr string) (*FTP, error) {
+	return connect(addr, nil, false)
 }
 
 // ConnectDbg to server at addr (format "host:port"). debug is ON
 func ConnectDbg(addr string) (*FTP, error) {
-	var err error
-	var conn net.Conn
+	return connect(addr, nil, true)
+}
+
+// ConnectVia connects to server at addr (format "host:port") using dial for
+// the control and data connections, e.g. one returned by HTTPProxyDialer.
+// debug is OFF
+func ConnectVia(addr string, dial DialFunc) (*FTP, error) {
+	return connect(addr, dial, false)
+}
+
+// ConnectTLS connects to server at addr (format "host:port") and secures the
+// control connection with AuthTLS. It fails with an error matching
+// ErrTLSRequired rather than proceed if the server rejects AUTH TLS, and the
+// session requires TLS, see SetRequireTLS. debug is OFF
+func ConnectTLS(addr string, config *tls.Config) (*FTP, error) {
+	ftp, err := connect(addr, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ftp.requireTLS = true
+	if err = ftp.AuthTLS(config); err != nil {
+		ftp.Close()
+		return nil, fmt.Errorf("%w: %s", ErrTLSRequired, strings.TrimSpace(err.Error()))
+	}
+
+	return ftp, nil
+}
 
-	if conn, err = net.Dial("tcp", addr); err != nil {
+// connect dials addr with dial, or a net.Dialer if nil, and reads the
+// greeting
+func connect(addr string, dial DialFunc, debug bool) (*FTP, error) {
+	conn, err := dialContext(context.Background(), dial, "tcp", addr)
+	if err != nil {
 		return nil, err
 	}
+	return newSession(conn, addr, dial, debug), nil
+}
+
+// newSession reads the greeting on the control connection conn to addr
+func newSession(conn net.Conn, addr string, dial DialFunc, debug bool) *FTP {
 
 	writer := bufio.NewWriter(conn)
 	reader := bufio.NewReader(conn)
 
-	var line string
+	object := &FTP{conn: conn, addr: addr, dial: dial, reader: reader, writer: writer, debug: debug}
+	line, _ := object.receive()
 
-	object := &FTP{conn: conn, addr: addr, reader: reader, writer: writer, debug: true}
-	line, _ = object.receive()
+	if debug {
+		log.Print(line)
+	}
 
-	log.Print(line)
+	object.banner = strings.TrimSpace(line)
+	object.applyQuirks()
 
-	return object, nil
+	return object
 }
 
 // Size returns the size of a file.
 func (ftp *FTP) Size(path string) (size int, err error) {
-	line, err := ftp.cmd("213", "SIZE %s", path)
+	if err = ftp.checkPath(path); err != nil {
+		return
+	}
+	line, err := ftp.cmd(StatusFileStatus, "SIZE %s", ftp.remotePath(path))
 
 	if err != nil {
 		return 0, err
@@ -933,6 +1311,12 @@ func parseRFC3659ListLine(line string, now time.Time, loc *time.Location) (*Entr
 			}
 		case "size":
 			e.setSize(value)
+		case "perm", "unix.mode":
+			e.Perm = value
+		case "unix.owner":
+			e.Owner = value
+		case "unix.group":
+			e.Group = value
 		}
 	}
 	return e, nil
@@ -992,7 +1376,10 @@ func parseLsListLine(line string, now time.Time, loc *time.Location) (*Entry, er
 	}
 
 	e := &Entry{
-		Name: scanner.Remaining(),
+		Name:  scanner.Remaining(),
+		Perm:  fields[0],
+		Owner: fields[2],
+		Group: fields[3],
 	}
 	switch fields[0][0] {
 	case '-':
@@ -1087,7 +1474,12 @@ func parseHostedFTPLine(line string, now time.Time, loc *time.Location) (*Entry,
 // parseListLine parses the various non-standard format returned by the LIST
 // FTP command.
 func parseListLine(line string, now time.Time, loc *time.Location) (*Entry, error) {
-	for _, f := range listLineParsers {
+	return parseListLineWith(listLineParsers, line, now, loc)
+}
+
+// parseListLineWith parses line with the first of parsers supporting it
+func parseListLineWith(parsers []parseFunc, line string, now time.Time, loc *time.Location) (*Entry, error) {
+	for _, f := range parsers {
 		e, err := f(line, now, loc)
 		if err != errUnsupportedListLine {
 			return e, err
@@ -1096,6 +1488,27 @@ func parseListLine(line string, now time.Time, loc *time.Location) (*Entry, erro
 	return nil, errUnsupportedListLine
 }
 
+// parseLenientListLine returns an Entry with only a name for a line no parser
+// supports: the text after the facts of MLSD-like lines, the last field
+// otherwise
+func parseLenientListLine(line string) (*Entry, error) {
+	if strings.HasPrefix(line, "total ") {
+		return nil, errUnsupportedListLine
+	}
+
+	var name string
+	if i := strings.Index(line, "; "); i >= 0 {
+		name = line[i+2:]
+	} else if fields := strings.Fields(line); len(fields) > 0 {
+		name = fields[len(fields)-1]
+	}
+	if name == "" || name == "." || name == ".." {
+		return nil, errUnsupportedListLine
+	}
+
+	return &Entry{Name: name}, nil
+}
+
 var listLineParsers = []parseFunc{
 	parseRFC3659ListLine,
 	parseLsListLine,
@@ -1115,66 +1528,47 @@ func newScanner(str string) *scanner {
 	}
 }
 
+// List2 lists the path (or current directory) and returns the unparsed lines
 func (ftp *FTP) List2(path string) (files []string, err error) {
-	if err = ftp.Type(TypeASCII); err != nil {
+	var lines [][]byte
+	if lines, err = ftp.ListRaw(path); err != nil {
 		return
 	}
 
-	var port int
-	if port, err = ftp.Pasv(); err != nil {
-		return
+	for _, line := range lines {
+		files = append(files, string(line))
 	}
 
-	// check if MLSD works
-	if err = ftp.send("MLSD %s", path); err != nil {
-	}
+	return
+}
 
+// ListRaw lists the path (or current directory) and returns each line exactly
+// as sent by the server, including the line terminator.
+func (ftp *FTP) ListRaw(path string) (lines [][]byte, err error) {
 	var pconn net.Conn
-	if pconn, err = ftp.newConnection(port); err != nil {
+	if pconn, _, err = ftp.openListing(path); err != nil {
 		return
 	}
 	defer pconn.Close()
 
-	var line string
-	if line, err = ftp.receiveNoDiscard(); err != nil {
-		return
-	}
-
-	if !strings.HasPrefix(line, StatusFileOK) {
-		// MLSD failed, lets try LIST
-		if err = ftp.send("LIST %s", path); err != nil {
-			return
-		}
-
-		if line, err = ftp.receiveNoDiscard(); err != nil {
-			return
-		}
-	}
-
 	reader := bufio.NewReader(pconn)
 
 	for {
-		line, err = reader.ReadString('\n')
+		var raw []byte
+		raw, err = reader.ReadBytes('\n')
+		if len(raw) > 0 {
+			lines = append(lines, raw)
+		}
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return
+			return nil, ftp.failTransfer(pconn, err)
 		}
-
-		files = append(files, string(line))
 	}
 	// Must close for vsftp tlsed conenction otherwise does not receive connection
 	pconn.Close()
 
-	if line, err = ftp.receive(); err != nil {
-		return
-	}
-
-	if !strings.HasPrefix(line, StatusClosingDataConnection) {
-		err = errors.New(line)
-		return
-	}
-
+	err = ftp.endTransfer(ftp.transferComplete(), true)
 	return
 }
 
@@ -1226,6 +1620,10 @@ func (e *Entry) setSize(str string) (err error) {
 }
 
 func (e *Entry) setTime(fields []string, now time.Time, loc *time.Location) (err error) {
+	if len(fields) < 3 {
+		return errUnsupportedListDate
+	}
+
 	if strings.Contains(fields[2], ":") { // contains time
 		thisYear, _, _ := now.Date()
 		timeStr := fmt.Sprintf("%s %s %d %s", fields[1], fields[0], thisYear, fields[2])
@@ -0,0 +1,150 @@
+package goftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	pathpkg "path"
+	"strings"
+)
+
+// ErrGuard is matched by errors.Is when an operation is refused by the
+// session's Guard
+var ErrGuard = errors.New("refused by guard")
+
+// Guard is a policy enforced client-side before commands are sent, so
+// services embedding the library can apply it uniformly
+type Guard struct {
+	// MaxUploadSize and MaxDownloadSize limit transfers, in bytes. Zero means
+	// no limit.
+	MaxUploadSize   int64
+	MaxDownloadSize int64
+
+	// AllowedExtensions lists the file extensions, like ".csv", which may be
+	// transferred. Empty allows all. The comparison ignores case.
+	AllowedExtensions []string
+
+	// DeniedPrefixes lists remote paths, like "/etc", which may not be
+	// accessed, nor anything below them. Relative paths are resolved against
+	// the working directory.
+	DeniedPrefixes []string
+}
+
+// SetGuard sets the policy enforced by the session; nil removes it
+func (ftp *FTP) SetGuard(guard *Guard) {
+	ftp.guard = guard
+	ftp.guardCwd = ""
+}
+
+// checkPath refuses paths below a denied prefix
+func (ftp *FTP) checkPath(path string) error {
+	if ftp.guard == nil || len(ftp.guard.DeniedPrefixes) == 0 {
+		return nil
+	}
+
+	if !pathpkg.IsAbs(path) {
+		if ftp.guardCwd == "" {
+			cwd, err := ftp.Pwd()
+			if err != nil {
+				return err
+			}
+			ftp.guardCwd = cwd
+		}
+		path = pathpkg.Join(ftp.guardCwd, path)
+	}
+	path = pathpkg.Clean(path)
+
+	for _, prefix := range ftp.guard.DeniedPrefixes {
+		prefix = pathpkg.Clean(prefix)
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return fmt.Errorf("%w: %s is below denied %s", ErrGuard, path, prefix)
+		}
+	}
+	return nil
+}
+
+// checkTransfer refuses transfers of path with a disallowed extension
+func (ftp *FTP) checkTransfer(path string) error {
+	if err := ftp.checkPath(path); err != nil {
+		return err
+	}
+	if ftp.guard == nil || len(ftp.guard.AllowedExtensions) == 0 {
+		return nil
+	}
+
+	ext := pathpkg.Ext(path)
+	for _, allowed := range ftp.guard.AllowedExtensions {
+		if strings.EqualFold(ext, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: extension of %s not allowed", ErrGuard, path)
+}
+
+// guardUpload checks r against the upload limit before the transfer when its
+// size is known, and returns r limited to it otherwise
+func (ftp *FTP) guardUpload(path string, r io.Reader) (io.Reader, error) {
+	if err := ftp.checkTransfer(path); err != nil {
+		return nil, err
+	}
+	if ftp.guard == nil || ftp.guard.MaxUploadSize <= 0 {
+		return r, nil
+	}
+
+	if size, ok := readerSize(r); ok && size > ftp.guard.MaxUploadSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes, more than %d", ErrGuard, path, size, ftp.guard.MaxUploadSize)
+	}
+	return &guardedReader{r: r, remaining: ftp.guard.MaxUploadSize, path: path}, nil
+}
+
+// guardDownload checks path against the download limit before the transfer
+// when the server tells its size, and returns the limit to apply to the data
+func (ftp *FTP) guardDownload(path string) (limit int64, err error) {
+	if err = ftp.checkTransfer(path); err != nil {
+		return
+	}
+	if ftp.guard == nil || ftp.guard.MaxDownloadSize <= 0 {
+		return 0, nil
+	}
+
+	if size, err := ftp.Size(path); err == nil && int64(size) > ftp.guard.MaxDownloadSize {
+		return 0, fmt.Errorf("%w: %s is %d bytes, more than %d", ErrGuard, path, size, ftp.guard.MaxDownloadSize)
+	}
+	return ftp.guard.MaxDownloadSize, nil
+}
+
+// readerSize returns the number of bytes left in r, if r can tell
+func readerSize(r io.Reader) (int64, bool) {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len()), true
+	case *os.File:
+		fi, err := v.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0, false
+		}
+		offset, err := v.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		return fi.Size() - offset, true
+	}
+	return 0, false
+}
+
+// guardedReader fails once more than remaining bytes are read
+type guardedReader struct {
+	r         io.Reader
+	remaining int64
+	path      string
+}
+
+func (g *guardedReader) Read(b []byte) (int, error) {
+	n, err := g.r.Read(b)
+	g.remaining -= int64(n)
+	if g.remaining < 0 {
+		return n, fmt.Errorf("%w: %s exceeds the size limit", ErrGuard, g.path)
+	}
+	return n, err
+}
@@ -0,0 +1,153 @@
+package goftp
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+)
+
+// Listing is a directory listing parsed lazily, one entry at a time, see
+// ListLazy. Its raw lines are kept in memory, or in a temporary file for
+// listings longer than the threshold set with SetListSpillThreshold.
+type Listing struct {
+	lines []string // in memory lines, if not spilled
+
+	file    *os.File // spilled lines
+	scanner *bufio.Scanner
+
+	count   int
+	parser  parseFunc
+	lenient bool
+	now     time.Time
+	loc     *time.Location
+}
+
+// SetListSpillThreshold makes ListLazy write the raw lines of listings having
+// more than n lines to a temporary file instead of keeping them in memory,
+// bounding the memory used for directories with millions of entries. Zero,
+// the default, keeps every listing in memory.
+func (ftp *FTP) SetListSpillThreshold(n int) {
+	ftp.listSpill = n
+}
+
+// ListLazy lists the path (or current directory) like List, but parses the
+// entries only as Next is called. Close the listing to remove its temporary
+// file, if any.
+func (ftp *FTP) ListLazy(path string) (listing *Listing, err error) {
+	pconn, mlsd, err := ftp.openListing(path)
+	if err != nil {
+		return nil, err
+	}
+	defer pconn.Close()
+
+	listing = &Listing{parser: ftp.listLineParser(), lenient: ftp.lenientList, now: time.Now(), loc: ftp.location}
+	if mlsd {
+		listing.parser = parseRFC3659ListLine
+	}
+	if listing.loc == nil {
+		listing.loc = time.UTC
+	}
+	defer func() {
+		if err != nil {
+			listing.Close()
+			listing = nil
+		}
+	}()
+
+	var spill *bufio.Writer
+	scanner := bufio.NewScanner(pconn)
+	for scanner.Scan() {
+		listing.count++
+		if spill == nil && ftp.listSpill > 0 && len(listing.lines) >= ftp.listSpill {
+			if listing.file, err = os.CreateTemp("", "goftp-listing-"); err != nil {
+				err = ftp.failTransfer(pconn, err)
+				return
+			}
+			spill = bufio.NewWriter(listing.file)
+			for _, line := range listing.lines {
+				spill.WriteString(line + "\n")
+			}
+			listing.lines = nil
+		}
+		if spill != nil {
+			spill.WriteString(scanner.Text() + "\n")
+		} else {
+			listing.lines = append(listing.lines, scanner.Text())
+		}
+	}
+	err = scanner.Err()
+	if err == nil && spill != nil {
+		if err = spill.Flush(); err == nil {
+			_, err = listing.file.Seek(0, io.SeekStart)
+		}
+		listing.scanner = bufio.NewScanner(listing.file)
+	}
+	if err != nil {
+		err = ftp.failTransfer(pconn, err)
+		return
+	}
+
+	// Close before reading the reply, as List does
+	pconn.Close()
+
+	err = ftp.endTransfer(ftp.transferComplete(), true)
+	return
+}
+
+// Len returns the number of lines of the listing, including the ones which
+// are not entries
+func (l *Listing) Len() int {
+	return l.count
+}
+
+// Next returns the next entry of the listing, or io.EOF at its end. Lines
+// which cannot be parsed are skipped, as by List.
+func (l *Listing) Next() (*Entry, error) {
+	for {
+		line, err := l.nextLine()
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := l.parser(line, l.now, l.loc)
+		if err != nil && l.lenient {
+			entry, err = parseLenientListLine(line)
+		}
+		if err == nil {
+			entry.Raw = line
+			return entry, nil
+		}
+	}
+}
+
+func (l *Listing) nextLine() (string, error) {
+	if l.scanner == nil {
+		if len(l.lines) == 0 {
+			return "", io.EOF
+		}
+		line := l.lines[0]
+		l.lines = l.lines[1:]
+		return line, nil
+	}
+
+	if l.scanner.Scan() {
+		return l.scanner.Text(), nil
+	}
+	if err := l.scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+// Close releases the listing and removes its temporary file
+func (l *Listing) Close() error {
+	l.lines = nil
+	if l.file == nil {
+		return nil
+	}
+	l.file.Close()
+	err := os.Remove(l.file.Name())
+	l.file = nil
+	return err
+}
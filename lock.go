@@ -0,0 +1,85 @@
+package goftp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// LockSuffix is appended to a file name to get the name of its lock marker
+const LockSuffix = ".lock"
+
+// ErrLocked is returned by Lock when another client holds the lock
+var ErrLocked = errors.New("remote file is locked")
+
+// Lock takes the advisory lock of path used by cooperating clients: it checks
+// that no "<path>.lock" marker exists, then uploads one. A marker older than
+// staleAfter, by the server's clock as compared to ours, is considered left
+// over by a crashed client and is replaced; with servers not implementing
+// MDTM, the age is read from the owner and time written in the marker. Zero
+// staleAfter never expires markers. As FTP has no atomic create, two clients racing within one round
+// trip may both get the lock.
+func (ftp *FTP) Lock(path string, staleAfter time.Duration) error {
+	lockPath := path + LockSuffix
+
+	modTime, err := ftp.ModTime(lockPath)
+	if isNotImplemented(err) {
+		modTime, err = ftp.lockTime(lockPath)
+	}
+	switch {
+	case err == nil:
+		if staleAfter == 0 || time.Since(modTime) < staleAfter {
+			return fmt.Errorf("%w: %s since %s", ErrLocked, path, modTime.Format(time.RFC3339))
+		}
+		if ftp.debug {
+			log.Printf("Removing stale lock %s from %s", lockPath, modTime)
+		}
+		if err = ftp.Dele(lockPath); err != nil {
+			return err
+		}
+	case replyCode(err) != StatusFileUnavailable:
+		return err
+	}
+
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s %d %s\n", host, os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+	return ftp.Stor(lockPath, strings.NewReader(owner))
+}
+
+// lockTime returns the time written in the marker at lockPath by Lock, after
+// the owner
+func (ftp *FTP) lockTime(lockPath string) (time.Time, error) {
+	var buf bytes.Buffer
+	if _, err := ftp.RetrTo(lockPath, &buf); err != nil {
+		return time.Time{}, err
+	}
+
+	fields := strings.Fields(buf.String())
+	if len(fields) == 0 {
+		return time.Time{}, fmt.Errorf("no time in lock marker %s", lockPath)
+	}
+	return time.Parse(time.RFC3339, fields[len(fields)-1])
+}
+
+// Unlock releases the lock of path taken with Lock
+func (ftp *FTP) Unlock(path string) error {
+	return ftp.Dele(path + LockSuffix)
+}
+
+// StorLocked uploads r to path like Stor while holding the lock of path
+func (ftp *FTP) StorLocked(path string, r io.Reader, staleAfter time.Duration) error {
+	if err := ftp.Lock(path, staleAfter); err != nil {
+		return err
+	}
+
+	err := ftp.Stor(path, r)
+	if unlockErr := ftp.Unlock(path); err == nil {
+		err = unlockErr
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package goftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestEntry is the receipt of a single uploaded file
+type ManifestEntry struct {
+	LocalPath string        `json:"local_path"`
+	Path      string        `json:"path"` // remote path, relative to the working directory
+	Size      int64         `json:"size"` // bytes sent
+	SHA256    string        `json:"sha256"`
+	Duration  time.Duration `json:"duration"`
+	Code      StatusCode    `json:"code"`            // completion reply, or the failing reply
+	Error     string        `json:"error,omitempty"` // set if the upload failed
+}
+
+// Manifest is the machine-readable receipt of a batch upload
+type Manifest struct {
+	Created time.Time       `json:"created"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// WriteTo writes the manifest as indented JSON
+func (m *Manifest) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// UploadWithManifest uploads every local path as UploadMany does, and returns
+// a manifest recording the size, the SHA-256 checksum, the duration and the
+// reply code of each file. Failed files are part of the manifest too, and are
+// also returned as a *BatchError.
+func (ftp *FTP) UploadWithManifest(localPaths []string) (*Manifest, error) {
+	m := &Manifest{Created: time.Now()}
+	record := func(localPath, serverPath string) error {
+		e, err := ftp.storRecorded(localPath, serverPath)
+		m.Entries = append(m.Entries, e)
+		return err
+	}
+
+	batch := &BatchError{}
+	for _, path := range localPaths {
+		fi, err := os.Stat(path)
+		switch {
+		case err != nil:
+		case fi.IsDir():
+			err = ftp.copyDir(path, ftp.Mkd, record)
+		case fi.Mode().IsRegular():
+			err = record(path, filepath.Base(path))
+		}
+		batch.add(path, err)
+	}
+	return m, batch.err()
+}
+
+// StorManifest uploads the manifest as JSON to path, e.g. alongside the data
+// it describes
+func (ftp *FTP) StorManifest(path string, m *Manifest) error {
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		return err
+	}
+	return ftp.Stor(path, &buf)
+}
+
+// storRecorded uploads the file at localPath to serverPath and returns its
+// manifest entry
+func (ftp *FTP) storRecorded(localPath, serverPath string) (e ManifestEntry, err error) {
+	e = ManifestEntry{LocalPath: localPath, Path: filepath.ToSlash(serverPath)}
+	defer func() {
+		if err != nil {
+			e.Error = err.Error()
+			if code := replyCode(err); code != 0 {
+				e.Code = code
+			}
+		}
+	}()
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	ftp.lastTransfer = nil
+	err = ftp.Stor(serverPath, io.TeeReader(file, h))
+	if stats := ftp.lastTransfer; stats != nil {
+		e.Size, e.Duration, e.Code = stats.Bytes, stats.Duration, stats.Reply
+	}
+	if err != nil {
+		return
+	}
+
+	e.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return
+}
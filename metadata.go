@@ -0,0 +1,121 @@
+package goftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mfmt sets the modification time of path on the remote host (RFC 3659 draft
+// MFMT command)
+func (ftp *FTP) Mfmt(path string, t time.Time) error {
+	if err := ftp.checkPath(path); err != nil {
+		return err
+	}
+	_, err := ftp.cmd(StatusFileStatus, "MFMT %s %s", t.UTC().Format("20060102150405"), ftp.remotePath(path))
+	return err
+}
+
+// ModTime returns the modification time of path on the remote host, using
+// the MDTM command
+func (ftp *FTP) ModTime(path string) (time.Time, error) {
+	if err := ftp.checkPath(path); err != nil {
+		return time.Time{}, err
+	}
+	line, err := ftp.cmd(StatusFileStatus, "MDTM %s", ftp.remotePath(path))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// 213 YYYYMMDDHHMMSS[.sss]
+	value := strings.TrimSpace(line[4:])
+	if len(value) < 14 {
+		return time.Time{}, errors.New("invalid MDTM reply: " + line)
+	}
+	return time.ParseInLocation("20060102150405", value[:14], time.UTC)
+}
+
+// Chmod changes the permissions of path on the remote host using SITE CHMOD
+func (ftp *FTP) Chmod(path string, mode os.FileMode) error {
+	if err := ftp.checkPath(path); err != nil {
+		return err
+	}
+	_, err := ftp.cmd(StatusOK, "SITE CHMOD %o %s", mode.Perm(), ftp.remotePath(path))
+	return err
+}
+
+// SetFacts modifies several facts of path at once with the MFF command (RFC
+// 3659 draft), e.g. {"modify": "20150813224845", "UNIX.mode": "0644"}. The
+// server must advertise MFF and every fact in FEAT, otherwise SetFacts fails
+// with an error matching ErrNotSupported before sending anything.
+func (ftp *FTP) SetFacts(path string, facts map[string]string) error {
+	if err := ftp.checkPath(path); err != nil {
+		return err
+	}
+
+	features, err := ftp.Features()
+	if err != nil {
+		return err
+	}
+	params, ok := features["MFF"]
+	if !ok {
+		return fmt.Errorf("%w: MFF", ErrNotSupported)
+	}
+	supported := map[string]bool{}
+	for _, fact := range strings.Split(params, ";") {
+		supported[strings.ToLower(fact)] = true
+	}
+
+	names := make([]string, 0, len(facts))
+	for name := range facts {
+		if !supported[strings.ToLower(name)] {
+			return fmt.Errorf("%w: MFF fact %s", ErrNotSupported, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + "=" + facts[name] + ";")
+	}
+	_, err = ftp.cmd(StatusFileStatus, "MFF %s %s", b.String(), ftp.remotePath(path))
+	return err
+}
+
+// StorWithMetadata uploads r to path like Stor, then sets its modification
+// time and permissions. Either step is skipped when the server does not
+// implement the command. A zero modTime or mode is not applied.
+func (ftp *FTP) StorWithMetadata(path string, r io.Reader, modTime time.Time, mode os.FileMode) error {
+	if err := ftp.Stor(path, r); err != nil {
+		return err
+	}
+
+	if !modTime.IsZero() {
+		if err := ftp.Mfmt(path, modTime); err != nil && !isNotImplemented(err) {
+			return err
+		}
+	}
+
+	if mode != 0 {
+		if err := ftp.Chmod(path, mode); err != nil && !isNotImplemented(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isNotImplemented reports whether err is a reply telling the command or its
+// parameter is not implemented by the server
+func isNotImplemented(err error) bool {
+	switch replyCode(err) {
+	case StatusSyntaxError, StatusCommandNotImplemented, StatusNotImplementedParam:
+		return true
+	}
+	return false
+}
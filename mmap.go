@@ -0,0 +1,116 @@
+package goftp
+
+import (
+	"io"
+	"math"
+	"os"
+)
+
+// mappedChunk is the size of the writes of a MappedFile to the data
+// connection
+const mappedChunk = 4 << 20
+
+// MappedFile is a read-only upload source over a local file mapped in memory.
+// Stor sends it straight from the mapping, in large chunks, without copying
+// it through an intermediate buffer first. Where the file cannot be mapped, it
+// is read like a regular file.
+//
+// The mapping is shared with the file: if another process truncates the file
+// while it is read, the process is killed with SIGBUS. Only use it for files
+// which are not rewritten during the upload.
+type MappedFile struct {
+	file   *os.File
+	data   []byte // nil if the file is not mapped
+	size   int64
+	offset int64
+}
+
+// SetMappedUploads makes Upload read local files through a MappedFile rather
+// than an *os.File. See MappedFile for the risk with files truncated during
+// the upload.
+func (ftp *FTP) SetMappedUploads(mapped bool) {
+	ftp.mappedUploads = mapped
+}
+
+// OpenMapped opens the file at path as a MappedFile
+func OpenMapped(path string) (*MappedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	m := &MappedFile{file: file, size: fi.Size()}
+	if fi.Mode().IsRegular() && m.size > 0 && m.size <= math.MaxInt {
+		// Fall back to reads if the mapping fails
+		m.data, _ = mmapFile(file, int(m.size))
+	}
+	return m, nil
+}
+
+// Read implements io.Reader
+func (m *MappedFile) Read(b []byte) (int, error) {
+	n, err := m.ReadAt(b, m.offset)
+	m.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt
+func (m *MappedFile) ReadAt(b []byte, off int64) (int, error) {
+	if m.data == nil {
+		return m.file.ReadAt(b, off)
+	}
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	n := copy(b, m.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteTo writes the rest of the file to w, directly from the mapping. It is
+// used by io.Copy.
+func (m *MappedFile) WriteTo(w io.Writer) (written int64, err error) {
+	if m.data == nil {
+		written, err = io.Copy(w, io.NewSectionReader(m.file, m.offset, m.size-m.offset))
+		m.offset += written
+		return
+	}
+
+	for m.offset < m.size {
+		end := m.offset + mappedChunk
+		if end > m.size {
+			end = m.size
+		}
+		n, err := w.Write(m.data[m.offset:end])
+		m.offset += int64(n)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Len returns the number of bytes not read yet
+func (m *MappedFile) Len() int {
+	return int(m.size - m.offset)
+}
+
+// Close unmaps and closes the file
+func (m *MappedFile) Close() error {
+	if m.data != nil {
+		munmapFile(m.data)
+		m.data = nil
+	}
+	return m.file.Close()
+}
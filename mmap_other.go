@@ -0,0 +1,18 @@
+//go:build !unix
+
+package goftp
+
+import (
+	"errors"
+	"os"
+)
+
+var errNoMmap = errors.New("memory mapping not supported")
+
+func mmapFile(file *os.File, size int) ([]byte, error) {
+	return nil, errNoMmap
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
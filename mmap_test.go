@@ -0,0 +1,42 @@
+package goftp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMappedFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), mappedChunk/5)
+	path := filepath.Join(t.TempDir(), "big")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := OpenMapped(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	head := make([]byte, 10)
+	if _, err = io.ReadFull(m, head); err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != len(content)-10 {
+		t.Errorf("Len is %d, want %d", m.Len(), len(content)-10)
+	}
+
+	var rest bytes.Buffer
+	if _, err = io.Copy(&rest, m); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(head, rest.Bytes()...), content) {
+		t.Error("content differs")
+	}
+	if n, err := m.Read(head); n != 0 || err != io.EOF {
+		t.Errorf("got %d, %v at the end, want io.EOF", n, err)
+	}
+}
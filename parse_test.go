@@ -0,0 +1,89 @@
+package goftp
+
+import (
+	"testing"
+	"time"
+)
+
+var listLineCorpus = []string{
+	"type=file;size=42;modify=20150813224845; file.txt",
+	"type=dir;modify=20150813224845;perm=flcdmpe; dir",
+	"modify=20150813224845;perm=fle;type=cdir;unique=119FBB87U4;UNIX.group=0;UNIX.mode=0755;UNIX.owner=0; .",
+	"-rw-r--r--   1 ftp      ftp          1024 Jun 10  1994 COPYING",
+	"drwxr-xr-x    6 4015     4015         4096 Aug 21 17:25 kernels",
+	"lrwxrwxrwx   1 root     other          7 Jan 25 00:17 bin -> usr/bin",
+	"-rw-r--r--+  1 ftp      ftp            42 Dec 31 23:59 acl",
+	"----------   1 owner    group         1803128 Jul 10 10:18 ls-lR.Z",
+	"d--------- 1 owner    group               0 May  9 19:45 Softlib",
+	"-rwxrwxrwx   1 noone    nogroup      322 Aug 19  1996 message.ftp",
+	"-r--------   0 user group     65222236 Feb 24 00:39 UABlacklistingWeek8.csv",
+	"drwxr-xr-x    3 110      1002            3 Dec 02  2009 spaces   dir   name",
+	"-rwxr-xr-x    1 ftp ftp 0 Jan 1 2000",
+	"dr-xr-xr-x   1 owner    group               0 Nov 25  2013 folder",
+	"-rw-r--r--   0 0 0 Jan 1 00:00 file",
+	"drwxr-xr-x   folder        0 Nov 17 11:41 dir",
+	"04-27-00  09:09PM       <DIR>          licensed",
+	"07-18-00  10:16AM       <DIR>          pub",
+	"04-14-00  03:47PM                  589 readme.htm",
+	"2016-04-05  14:19                 1024 file",
+	"total 42",
+	"",
+	";",
+	" ",
+	"=;x",
+	"type=;",
+	"-rw-r--r-- 1",
+	"-rw-r--r-- 1 a b c d e",
+	"-rw-r--r-- 0 a b c d e",
+	"01-02-06  03:04PM",
+}
+
+func FuzzParseListLine(f *testing.F) {
+	for _, line := range listLineCorpus {
+		f.Add(line)
+	}
+
+	now := time.Date(2016, 4, 5, 0, 0, 0, 0, time.UTC)
+	f.Fuzz(func(t *testing.T, line string) {
+		for _, parse := range append(listLineParsers, parseListLine) {
+			if e, err := parse(line, now, time.UTC); err == nil && e == nil {
+				t.Errorf("%q: nil entry without error", line)
+			}
+		}
+		parseLenientListLine(line)
+		parseLine(line)
+	})
+}
+
+func TestParseLenientListLine(t *testing.T) {
+	for line, want := range map[string]string{
+		"weird;facts; some name":      "some name",
+		"?????????? ? ? ? ? ? broken": "broken",
+	} {
+		e, err := parseLenientListLine(line)
+		if err != nil || e.Name != want {
+			t.Errorf("%q: got %+v, %v, want name %q", line, e, err, want)
+		}
+	}
+	if _, err := parseLenientListLine("total 42"); err == nil {
+		t.Error("total line should be skipped")
+	}
+}
+
+func TestParseIdleTimeout(t *testing.T) {
+	cases := map[string]time.Duration{
+		"220---------- Welcome to Pure-FTPd [privsep] [TLS] ----------\n" +
+			"220-You are user number 1 of 50 allowed.\n" +
+			"220-You will be disconnected after 15 minutes of inactivity.\n" +
+			"220 Local time is now 10:00.": 15 * time.Minute,
+		"220 Idle timeout is 300 seconds": 300 * time.Second,
+		"220 (vsFTPd 3.0.3)":              0,
+		"220 Ready, 5 minutes left":       0,
+	}
+	for banner, want := range cases {
+		got, ok := parseIdleTimeout(banner)
+		if got != want || ok != (want != 0) {
+			t.Errorf("%q: got %v %v, want %v", banner, got, ok, want)
+		}
+	}
+}
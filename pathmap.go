@@ -0,0 +1,30 @@
+package goftp
+
+import "strings"
+
+// WindowsPathMapper returns a PathMapper for old Windows servers which only
+// accept backslash separated paths. Slashes become backslashes, and a leading
+// slash before a drive letter ("/C:/dir") is dropped so the drive is the root.
+func WindowsPathMapper() PathMapper {
+	return func(path string) string {
+		if len(path) >= 3 && path[0] == '/' && path[2] == ':' && isLetter(path[1]) {
+			path = path[1:]
+		}
+		return strings.Replace(path, "/", `\`, -1)
+	}
+}
+
+// ChainPathMappers returns a PathMapper applying mappers in order, e.g. a
+// deployment prefix followed by WindowsPathMapper
+func ChainPathMappers(mappers ...PathMapper) PathMapper {
+	return func(path string) string {
+		for _, m := range mappers {
+			path = m(path)
+		}
+		return path
+	}
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
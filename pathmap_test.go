@@ -0,0 +1,19 @@
+package goftp
+
+import "testing"
+
+func TestWindowsPathMapper(t *testing.T) {
+	mapper := WindowsPathMapper()
+	for path, want := range map[string]string{
+		"dir/file.txt":   `dir\file.txt`,
+		"/C:/data/in":    `C:\data\in`,
+		"C:/data":        `C:\data`,
+		"/root/file":     `\root\file`,
+		"":               "",
+		`already\native`: `already\native`,
+	} {
+		if got := mapper(path); got != want {
+			t.Errorf("%q mapped to %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package goftp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// DialFunc connects to addr on the named network, with the same semantics as
+// net.Dial. It is used for both the control and the data connections.
+type DialFunc func(network, addr string) (net.Conn, error)
+
+// HTTPProxyDialer returns a DialFunc that tunnels every connection through the
+// HTTP proxy at proxyURL using the CONNECT method. If proxyURL carries user
+// information it is sent as basic Proxy-Authorization. An "https" scheme
+// makes the connection to the proxy itself use TLS.
+func HTTPProxyDialer(proxyURL *url.URL) DialFunc {
+	return func(network, addr string) (net.Conn, error) {
+		proxyAddr := proxyURL.Host
+		if proxyURL.Port() == "" {
+			if proxyURL.Scheme == "https" {
+				proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "443")
+			} else {
+				proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "8080")
+			}
+		}
+
+		var conn net.Conn
+		var err error
+		if proxyURL.Scheme == "https" {
+			conn, err = tls.Dial(network, proxyAddr, &tls.Config{ServerName: proxyURL.Hostname()})
+		} else {
+			conn, err = net.Dial(network, proxyAddr)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+		if u := proxyURL.User; u != nil {
+			password, _ := u.Password()
+			auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+			req.Header.Set("Proxy-Authorization", "Basic "+auth)
+		}
+		if err = req.Write(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, errors.New("proxy CONNECT " + addr + ": " + resp.Status)
+		}
+
+		// The FTP greeting may already sit in the reader behind the proxy reply
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+}
+
+// bufferedConn is a net.Conn whose reads are served from reader first.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,51 @@
+package goftp
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestHTTPProxyDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect || req.Host != "ftp.example.com:21" ||
+			req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return
+		}
+		// Greeting in the same write as the proxy reply
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n220 ready\r\n"))
+	}()
+
+	dial := HTTPProxyDialer(&url.URL{Scheme: "http", Host: l.Addr().String(), User: url.UserPassword("user", "secret")})
+	conn, err := dial("tcp", "ftp.example.com:21")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "220 ready\r\n" {
+		t.Errorf("got %q, want greeting", line)
+	}
+}
@@ -0,0 +1,57 @@
+package goftp
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrReadOnly is returned for commands modifying the server in a read-only
+// session
+var ErrReadOnly = errors.New("read-only session")
+
+// mutatingCommands modify files or directories on the server
+var mutatingCommands = map[string]bool{
+	"STOR": true,
+	"STOU": true,
+	"APPE": true,
+	"ALLO": true,
+	"DELE": true,
+	"RNFR": true,
+	"RNTO": true,
+	"MKD":  true,
+	"XMKD": true,
+	"RMD":  true,
+	"XRMD": true,
+	"MFMT": true,
+	"MFCT": true,
+	"MFF":  true,
+}
+
+// SetReadOnly makes the session refuse commands which modify the server,
+// before sending them, with ErrReadOnly. SITE commands other than SITE HELP
+// are refused too, as they commonly change permissions or files.
+func (ftp *FTP) SetReadOnly(readOnly bool) {
+	ftp.readOnly = readOnly
+}
+
+// checkWritable returns ErrReadOnly in a read-only session. Operations which
+// prepare a data connection or list before their first mutating command call
+// it upfront, so they are refused before anything is sent.
+func (ftp *FTP) checkWritable() error {
+	if ftp.readOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// isMutating reports whether the command line modifies the server
+func isMutating(command string) bool {
+	fields := strings.Fields(strings.ToUpper(command))
+	if len(fields) == 0 {
+		return false
+	}
+	if fields[0] == "SITE" {
+		return len(fields) < 2 || fields[1] != "HELP"
+	}
+	return mutatingCommands[fields[0]]
+}
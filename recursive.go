@@ -0,0 +1,124 @@
+package goftp
+
+import (
+	"bufio"
+	pathpkg "path"
+	"strings"
+	"time"
+)
+
+// ListRecursive lists the tree at path and returns every entry below it,
+// files and folders, named by their path joined to path. It asks for the
+// whole tree on one data connection with LIST -R, and falls back to listing
+// each folder when the server does not support it.
+func (ftp *FTP) ListRecursive(path string) ([]*Entry, error) {
+	entries, ok, err := ftp.listR(path)
+	if err != nil {
+		if replyCode(err) == 0 {
+			return nil, err
+		}
+		ok = false
+	}
+	if ok {
+		return entries, nil
+	}
+
+	entries = nil
+	err = ftp.WalkEntries(path, func(p string, e *Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		full := *e
+		full.Name = p
+		entries = append(entries, &full)
+		return nil
+	})
+	return entries, err
+}
+
+// listR lists path with LIST -R. ok is false if the server ignored -R,
+// which shows as folders without their sections.
+func (ftp *FTP) listR(path string) (entries []*Entry, ok bool, err error) {
+	if path != "" {
+		if err = ftp.checkPath(path); err != nil {
+			return
+		}
+	}
+	if err = ftp.Type(TypeASCII); err != nil {
+		return
+	}
+	ftp.detectSystem()
+
+	pconn, err := ftp.openDataConn("LIST -R", listArgument(path), 0, false)
+	if err != nil {
+		return
+	}
+	defer pconn.Close()
+
+	loc := ftp.location
+	if loc == nil {
+		loc = time.UTC
+	}
+	parser := ftp.listLineParser()
+
+	folders, sections := 0, 0
+	dir := path
+	scanner := bufio.NewScanner(pconn)
+	now := time.Now()
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		entry, perr := parser(line, now, loc)
+		if perr != nil {
+			if strings.HasSuffix(line, ":") {
+				dir = recursiveSection(path, strings.TrimSuffix(line, ":"))
+				sections++
+			}
+			continue
+		}
+		if isSelfOrParent(entry) {
+			continue
+		}
+		if entry.Type == EntryTypeFolder {
+			folders++
+		}
+		entry.Raw = line
+		entry.Name = pathpkg.Join(dir, entry.Name)
+		entries = append(entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		err = ftp.failTransfer(pconn, err)
+		return
+	}
+
+	// Close before reading the reply, as List does
+	pconn.Close()
+
+	if err = ftp.endTransfer(ftp.transferComplete(), true); err != nil {
+		return
+	}
+
+	// ls -R has a section for every folder, plus one for path itself with
+	// some servers
+	return entries, folders == 0 || sections >= folders, nil
+}
+
+// recursiveSection returns the folder of a LIST -R section header: ".",
+// "./sub", "sub" relative to path, or an absolute path
+func recursiveSection(path, header string) string {
+	if strings.HasPrefix(header, "/") {
+		return header
+	}
+	header = strings.TrimPrefix(header, "./")
+	if header == "." {
+		return path
+	}
+	if path != "" && (header == path || strings.HasPrefix(header, path+"/")) {
+		// Some servers repeat the argument in the headers
+		return header
+	}
+	return pathpkg.Join(path, header)
+}
@@ -0,0 +1,214 @@
+package goftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"os"
+)
+
+// resumeSaveInterval is how many bytes are transferred between saves of the
+// transfer state
+const resumeSaveInterval = 8 << 20
+
+// TransferState is the progress of a resumable transfer
+type TransferState struct {
+	RemotePath string
+	Size       int64  // total size of the file
+	Offset     uint64 // bytes transferred
+	PrefixHash []byte // SHA-256 of the first Offset bytes
+}
+
+// ResumeStore persists transfer states across process restarts. LoadState
+// returns nil and no error when there is no state for key.
+type ResumeStore interface {
+	LoadState(key string) (*TransferState, error)
+	SaveState(key string, state *TransferState) error
+	DeleteState(key string) error
+}
+
+// StorResumable uploads size bytes from r to path, saving its progress in
+// store. If a previous upload of the same content was interrupted, it is
+// resumed where the server's copy ends, provided the local content did not
+// change since. The upload starts over if the server refuses to restart it.
+func (ftp *FTP) StorResumable(path string, r io.ReadSeeker, size int64, store ResumeStore) error {
+	if err := ftp.checkWritable(); err != nil {
+		return err
+	}
+
+	key := "STOR " + ftp.addr + " " + path
+	state, err := store.LoadState(key)
+	if err != nil {
+		return err
+	}
+
+	// Servers like ProFTPD refuse SIZE in ASCII mode
+	if err = ftp.Type(TypeImage); err != nil {
+		return err
+	}
+
+	var offset uint64
+	h := sha256.New()
+	if state != nil && state.Size == size && state.Offset > 0 {
+		if remoteSize, err := ftp.Size(path); err == nil && uint64(remoteSize) <= state.Offset {
+			if ok, err := verifyPrefix(r, state, uint64(remoteSize), h); err != nil {
+				return err
+			} else if ok {
+				offset = uint64(remoteSize)
+			}
+		}
+	}
+	if _, err = r.Seek(int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+	progress := &resumeProgress{
+		store: store,
+		key:   key,
+		state: TransferState{RemotePath: path, Size: size, Offset: offset},
+		hash:  h,
+	}
+
+	src := io.TeeReader(r, progress)
+	if offset > 0 {
+		err = ftp.StorFrom(path, src, offset)
+	}
+	if offset == 0 || errors.Is(err, ErrRestartNotSupported) {
+		progress.reset()
+		if _, err = r.Seek(0, io.SeekStart); err == nil {
+			err = ftp.Stor(path, src)
+		}
+	}
+	if err != nil {
+		progress.save()
+		return err
+	}
+
+	return store.DeleteState(key)
+}
+
+// RetrResumable downloads path into f, saving its progress in store. If a
+// previous download of the same remote file into f was interrupted, it is
+// resumed where it stopped, provided f was not modified since. The download
+// starts over if the server refuses to restart it.
+func (ftp *FTP) RetrResumable(path string, f *os.File, store ResumeStore) error {
+	key := "RETR " + ftp.addr + " " + path
+	state, err := store.LoadState(key)
+	if err != nil {
+		return err
+	}
+
+	// Servers like ProFTPD refuse SIZE in ASCII mode
+	if err = ftp.Type(TypeImage); err != nil {
+		return err
+	}
+
+	remoteSize, err := ftp.Size(path)
+	if err != nil {
+		return err
+	}
+
+	var offset uint64
+	h := sha256.New()
+	if state != nil && state.Size == int64(remoteSize) && state.Offset > 0 {
+		if ok, err := verifyPrefix(f, state, state.Offset, h); err != nil {
+			return err
+		} else if ok {
+			offset = state.Offset
+		}
+	}
+	if err = rewind(f, offset); err != nil {
+		return err
+	}
+	progress := &resumeProgress{
+		store: store,
+		key:   key,
+		state: TransferState{RemotePath: path, Size: int64(remoteSize), Offset: offset},
+		hash:  h,
+	}
+
+	retrFn := func(r io.Reader) error {
+		_, err := io.Copy(io.MultiWriter(f, progress), r)
+		return err
+	}
+	if offset > 0 {
+		err = ftp.RetrFrom(path, offset, retrFn)
+	}
+	if offset == 0 || errors.Is(err, ErrRestartNotSupported) {
+		progress.reset()
+		if err = rewind(f, 0); err == nil {
+			_, err = ftp.Retr(path, retrFn)
+		}
+	}
+	if err != nil {
+		progress.save()
+		return err
+	}
+
+	return store.DeleteState(key)
+}
+
+// rewind truncates f to offset and seeks there
+func rewind(f *os.File, offset uint64) error {
+	if err := f.Truncate(int64(offset)); err != nil {
+		return err
+	}
+	_, err := f.Seek(int64(offset), io.SeekStart)
+	return err
+}
+
+// verifyPrefix checks that the first state.Offset bytes of r still hash to
+// state.PrefixHash. h is left with the hash of the first n bytes.
+func verifyPrefix(r io.ReadSeeker, state *TransferState, n uint64, h hash.Hash) (bool, error) {
+	if n > state.Offset {
+		return false, errors.New("resume offset beyond verified prefix")
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	full := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(full, h), r, int64(n)); err != nil {
+		return false, nil
+	}
+	if _, err := io.CopyN(full, r, int64(state.Offset-n)); err != nil {
+		return false, nil
+	}
+	return bytes.Equal(full.Sum(nil), state.PrefixHash), nil
+}
+
+// resumeProgress hashes the transferred bytes and saves the state regularly
+type resumeProgress struct {
+	store     ResumeStore
+	key       string
+	state     TransferState
+	hash      hash.Hash
+	sinceSave uint64
+}
+
+func (p *resumeProgress) Write(b []byte) (int, error) {
+	p.hash.Write(b)
+	p.state.Offset += uint64(len(b))
+	p.sinceSave += uint64(len(b))
+	if p.sinceSave >= resumeSaveInterval {
+		if err := p.save(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// reset restarts the progress from the beginning of the file
+func (p *resumeProgress) reset() {
+	p.hash.Reset()
+	p.state.Offset = 0
+	p.sinceSave = 0
+}
+
+func (p *resumeProgress) save() error {
+	p.sinceSave = 0
+	state := p.state
+	state.PrefixHash = p.hash.Sum(nil)
+	return p.store.SaveState(p.key, &state)
+}
@@ -0,0 +1,2378 @@
+package goftp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer is a minimal in-process FTP server, enough to exercise the
+// client over a real TCP control and passive data connection.
+type testServer struct {
+	t        *testing.T
+	listener net.Listener
+	start    sync.Once
+
+	// greeting replaces the default 220 reply on connection
+	greeting string
+
+	// foreignFirst makes another host connect to the client first in active
+	// mode, as an attacker racing the server would
+	foreignFirst bool
+
+	// earlyComplete sends the completion reply of downloads and listings
+	// right after the preliminary one, before the data connection is done.
+	earlyComplete bool
+
+	mu       sync.Mutex
+	files    map[string][]byte
+	modTimes map[string]time.Time // set on STOR
+	dirs     map[string][]string  // MLSD lines per directory
+	// replies overrides the reply to a command verb, e.g. "MLSD": "500 no".
+	// An empty reply makes the server ignore the command.
+	replies map[string]string
+	// once overrides the reply to the next command of a verb only
+	once map[string]string
+	// verbs records every command verb received, in order
+	verbs []string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{
+		t:        t,
+		listener: l,
+		files:    map[string][]byte{},
+		modTimes: map[string]time.Time{},
+		dirs:     map[string][]string{},
+		replies:  map[string]string{},
+		once:     map[string]string{},
+	}
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+// Addr starts serving, once the test has set the server up, and returns the
+// address to connect to
+func (s *testServer) Addr() string {
+	s.start.Do(func() { go s.serve() })
+	return s.listener.Addr().String()
+}
+
+// connect returns a logged in session to the server
+func (s *testServer) connect() *FTP {
+	ftp, err := Connect(s.Addr())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if err = ftp.Login("anonymous", "anonymous"); err != nil {
+		s.t.Fatal(err)
+	}
+	s.t.Cleanup(func() { ftp.Close() })
+	return ftp
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	var data net.Listener
+	var renameFrom string
+	var active string // address to connect to after PORT or EPRT
+	var rest int      // offset of the next transfer after REST
+	binary := false
+	defer func() {
+		if data != nil {
+			data.Close()
+		}
+	}()
+
+	s.mu.Lock()
+	greeting := s.greeting
+	s.mu.Unlock()
+	if greeting == "" {
+		greeting = "220 test server ready"
+	}
+	reply("%s", greeting)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+
+		s.mu.Lock()
+		s.verbs = append(s.verbs, verb)
+		override, ok := s.once[verb]
+		if ok {
+			delete(s.once, verb)
+		} else {
+			override, ok = s.replies[verb]
+		}
+		s.mu.Unlock()
+		if ok {
+			if override != "" {
+				reply("%s", override)
+			}
+			continue
+		}
+
+		switch verb {
+		case "USER":
+			reply("331 password please")
+		case "PASS":
+			reply("230 logged in")
+		case "TYPE":
+			binary = arg == "I"
+			reply("200 ok")
+		case "NOOP":
+			reply("200 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		case "CWD":
+			reply("250 directory changed")
+		case "RNFR":
+			s.mu.Lock()
+			_, ok := s.files[arg]
+			s.mu.Unlock()
+			if !ok {
+				reply("550 not found")
+				continue
+			}
+			renameFrom = arg
+			reply("350 ready for destination")
+		case "RNTO":
+			if renameFrom == "" {
+				reply("503 RNFR first")
+				continue
+			}
+			s.mu.Lock()
+			s.files[arg] = s.files[renameFrom]
+			delete(s.files, renameFrom)
+			s.mu.Unlock()
+			renameFrom = ""
+			reply("250 renamed")
+		case "RMD":
+			s.mu.Lock()
+			_, ok := s.dirs[arg]
+			delete(s.dirs, arg)
+			s.mu.Unlock()
+			if !ok {
+				reply("550 not found")
+				continue
+			}
+			reply("250 removed")
+		case "PWD":
+			reply(`257 "/" is the current directory`)
+		case "SIZE":
+			// Like ProFTPD, which refuses SIZE in ASCII mode
+			if !binary {
+				reply("550 SIZE not allowed in ASCII mode")
+				continue
+			}
+			s.mu.Lock()
+			content, ok := s.files[arg]
+			s.mu.Unlock()
+			if !ok {
+				reply("550 not found")
+				continue
+			}
+			reply("213 %d", len(content))
+		case "REST":
+			if rest, err = strconv.Atoi(arg); err != nil {
+				reply("501 bad offset")
+				continue
+			}
+			reply("350 Restarting at %d", rest)
+		case "MDTM":
+			s.mu.Lock()
+			_, ok := s.files[arg]
+			modTime := s.modTimes[arg]
+			s.mu.Unlock()
+			if !ok {
+				reply("550 not found")
+				continue
+			}
+			reply("213 %s", modTime.UTC().Format("20060102150405"))
+		case "MFMT":
+			fields := strings.SplitN(arg, " ", 2)
+			modTime, err := time.Parse("20060102150405", fields[0])
+			if err != nil || len(fields) != 2 {
+				reply("501 syntax error")
+				continue
+			}
+			s.mu.Lock()
+			_, ok := s.files[fields[1]]
+			if ok {
+				s.modTimes[fields[1]] = modTime
+			}
+			s.mu.Unlock()
+			if !ok {
+				reply("550 not found")
+				continue
+			}
+			reply("213 Modify=%s; %s", fields[0], fields[1])
+		case "DELE":
+			s.mu.Lock()
+			_, ok := s.files[arg]
+			delete(s.files, arg)
+			s.mu.Unlock()
+			if !ok {
+				reply("550 not found")
+				continue
+			}
+			reply("250 deleted")
+		case "PASV":
+			if data, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply("425 cannot listen")
+				continue
+			}
+			port := data.Addr().(*net.TCPAddr).Port
+			reply("227 Entering Passive Mode (127,0,0,1,%d,%d)", port>>8, port&0xff)
+		case "PORT":
+			var h [4]int
+			var p1, p2 int
+			if _, err := fmt.Sscanf(arg, "%d,%d,%d,%d,%d,%d", &h[0], &h[1], &h[2], &h[3], &p1, &p2); err != nil {
+				reply("501 bad PORT")
+				continue
+			}
+			active = fmt.Sprintf("%d.%d.%d.%d:%d", h[0], h[1], h[2], h[3], p1<<8|p2)
+			reply("200 PORT ok")
+		case "EPRT":
+			parts := strings.Split(arg, "|")
+			if len(parts) != 5 {
+				reply("501 bad EPRT")
+				continue
+			}
+			active = net.JoinHostPort(parts[2], parts[3])
+			reply("200 EPRT ok")
+		case "RETR", "MLSD", "LIST", "STOR":
+			var dconn net.Conn
+			switch {
+			case active != "":
+				if s.foreignFirst {
+					foreign := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}
+					if fconn, err := foreign.Dial("tcp", active); err == nil {
+						io.WriteString(fconn, "injected")
+						fconn.Close()
+					}
+				}
+				dconn, err = net.Dial("tcp", active)
+				active = ""
+			case data != nil:
+				dconn, err = data.Accept()
+				data.Close()
+				data = nil
+			default:
+				reply("425 use PASV or PORT first")
+				continue
+			}
+			if err != nil {
+				return
+			}
+			s.transfer(verb, arg, rest, dconn, reply)
+			rest = 0
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *testServer) transfer(verb, arg string, offset int, dconn net.Conn, reply func(string, ...interface{})) {
+	s.mu.Lock()
+	content, isFile := s.files[arg]
+	lines, isDir := s.dirs[arg]
+	s.mu.Unlock()
+
+	if (verb == "RETR" && !isFile) || ((verb == "MLSD" || verb == "LIST") && !isDir) || offset > len(content) {
+		dconn.Close()
+		reply("550 not found")
+		return
+	}
+
+	early := s.earlyComplete && verb != "STOR"
+	reply("150 opening data connection")
+	if early {
+		reply("226 transfer complete")
+	}
+
+	switch verb {
+	case "RETR":
+		dconn.Write(content[offset:])
+	case "MLSD", "LIST":
+		for _, l := range lines {
+			io.WriteString(dconn, l+"\r\n")
+		}
+	case "STOR":
+		// A restarted upload continues the existing file
+		var buf bytes.Buffer
+		buf.Write(content[:offset])
+		io.Copy(&buf, dconn)
+		s.mu.Lock()
+		s.files[arg] = buf.Bytes()
+		s.modTimes[arg] = time.Now()
+		s.mu.Unlock()
+	}
+	dconn.Close()
+
+	if !early {
+		reply("226 transfer complete")
+	}
+}
+
+func TestEmptyTransfers(t *testing.T) {
+	for _, early := range []bool{false, true} {
+		s := newTestServer(t)
+		s.earlyComplete = early
+		s.files["empty"] = []byte{}
+		s.dirs["emptydir"] = nil
+		ftp := s.connect()
+
+		if err := ftp.Stor("upload", bytes.NewReader(nil)); err != nil {
+			t.Fatalf("early=%v: Stor of empty reader: %v", early, err)
+		}
+		s.mu.Lock()
+		content, ok := s.files["upload"]
+		s.mu.Unlock()
+		if !ok || len(content) != 0 {
+			t.Errorf("early=%v: uploaded %q", early, content)
+		}
+
+		var n int64
+		_, err := ftp.Retr("empty", func(r io.Reader) (err error) {
+			n, err = io.Copy(io.Discard, r)
+			return
+		})
+		if err != nil || n != 0 {
+			t.Fatalf("early=%v: Retr of empty file: %d bytes, %v", early, n, err)
+		}
+
+		entries, err := ftp.List("emptydir")
+		if err != nil || len(entries) != 0 {
+			t.Fatalf("early=%v: List of empty directory: %v, %v", early, entries, err)
+		}
+
+		// The session must still be in sync
+		if err = ftp.Noop(); err != nil {
+			t.Fatalf("early=%v: Noop after transfers: %v", early, err)
+		}
+	}
+}
+
+func TestListFallsBackToList(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["MLSD"] = "500 MLSD not understood"
+	s.dirs["dir"] = []string{"-rw-r--r--   1 ftp ftp          42 Jun 10  1994 COPYING"}
+	ftp := s.connect()
+
+	entries, err := ftp.List("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "COPYING" || entries[0].Size != 42 {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+func TestSystemDetectedOnList(t *testing.T) {
+	for syst, want := range map[string]ServerSystem{
+		"215 Windows_NT":      SystemWindowsNT,
+		"215 UNIX Type: L8":   SystemUnix,
+		"502 not implemented": SystemUnknown,
+	} {
+		s := newTestServer(t)
+		s.replies["SYST"] = syst
+		s.replies["MLSD"] = "500 MLSD not understood"
+		s.dirs["dir"] = []string{"08-13-15  10:48PM                  123 file.txt"}
+		ftp := s.connect()
+
+		for i := 0; i < 2; i++ {
+			entries, err := ftp.List("dir")
+			if err != nil {
+				t.Fatalf("%q: %v", syst, err)
+			}
+			if len(entries) != 1 || entries[0].Name != "file.txt" || entries[0].Size != 123 {
+				t.Errorf("%q: unexpected entries %+v", syst, entries)
+			}
+		}
+		if system, err := ftp.System(); err != nil || system != want {
+			t.Errorf("%q: got %s, %v, want %s", syst, system, err, want)
+		}
+		if n := s.countVerb("SYST", 0); n != 1 {
+			t.Errorf("%q: SYST sent %d times, want once", syst, n)
+		}
+	}
+
+	// Listing with MLSD does not need the system
+	s := newTestServer(t)
+	s.dirs["dir"] = []string{"type=file;size=1; a"}
+	if _, err := s.connect().List("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.countVerb("SYST", 0); n != 0 {
+		t.Errorf("SYST sent %d times with MLSD", n)
+	}
+}
+
+func TestSystemPathsSentAsGiven(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["SYST"] = "215 VMS OpenVMS V8.4"
+	s.files["DISK$USER:[DIR.SUB]FILE.TXT;1"] = []byte("vms")
+	ftp := s.connect()
+
+	if system, err := ftp.System(); err != nil || system != SystemVMS {
+		t.Fatalf("got %s, %v", system, err)
+	}
+	if err := ftp.Dele("DISK$USER:[DIR.SUB]FILE.TXT;1"); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) != 0 {
+		t.Errorf("file not deleted: %v", s.files)
+	}
+}
+
+func TestServerSoftware(t *testing.T) {
+	s := newTestServer(t)
+	s.mu.Lock()
+	s.greeting = "220 (vsFTPd 3.0.3)"
+	s.mu.Unlock()
+	s.dirs["dir"] = []string{"-rw-r--r--   1 ftp ftp          42 Jun 10  1994 COPYING"}
+	ftp := s.connect()
+
+	if software := ftp.ServerSoftware(); software != SoftwareVsftpd {
+		t.Errorf("got %v, want vsftpd", software)
+	}
+	if _, err := ftp.List("dir"); err != nil {
+		t.Fatal(err)
+	}
+	if s.countVerb("MLSD", 0) != 0 {
+		t.Error("MLSD sent to vsftpd")
+	}
+
+	// Told by SYST, which is asked once
+	s = newTestServer(t)
+	s.replies["SYST"] = "215 UNIX emulated by FileZilla"
+	ftp = s.connect()
+	for i := 0; i < 2; i++ {
+		if software := ftp.ServerSoftware(); software != SoftwareFileZilla {
+			t.Errorf("got %v, want FileZilla", software)
+		}
+	}
+	if n := s.countVerb("SYST", 0); n != 1 {
+		t.Errorf("SYST sent %d times, want once", n)
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	s := newTestServer(t)
+	s.dirs["tree"] = []string{"type=cdir; .", "type=file;size=3; a", "type=dir; sub"}
+	s.dirs["tree/sub"] = []string{"type=file;size=4; b", "type=file;size=5; c"}
+	ftp := s.connect()
+
+	bytes, files, err := ftp.DirSize("tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes != 12 || files != 3 {
+		t.Errorf("got %d bytes in %d files, want 12 in 3", bytes, files)
+	}
+}
+
+func TestCompareTree(t *testing.T) {
+	s := newTestServer(t)
+	s.dirs["remote"] = []string{
+		"type=file;size=4;modify=20150813224845; same",
+		"type=file;size=9;modify=20150813224845; resized",
+		"type=file;size=4;modify=20150813224845; touched",
+		"type=file;size=4;modify=20150813224845; remote-only",
+		"type=dir; sub",
+	}
+	s.dirs["remote/sub"] = []string{"type=file;size=4;modify=20150813224845; deep"}
+	ftp := s.connect()
+
+	local := t.TempDir()
+	modTime := time.Date(2015, 8, 13, 22, 48, 45, 0, time.UTC)
+	for name, mtime := range map[string]time.Time{
+		"same":       modTime.Add(30 * time.Second),
+		"resized":    modTime,
+		"touched":    modTime.Add(2 * time.Hour),
+		"local-only": modTime,
+		"sub/deep":   modTime,
+	} {
+		path := filepath.Join(local, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	comparisons, err := ftp.CompareTree(local, "remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range comparisons {
+		got = append(got, fmt.Sprintf("%s:%d", c.Path, c.Result))
+	}
+	want := fmt.Sprintf("local-only:%d remote-only:%d resized:%d same:%d sub/deep:%d touched:%d",
+		CompareOnlyLocal, CompareOnlyRemote, CompareSizeDiffers, CompareSame, CompareSame, CompareTimeDiffers)
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, " "), want)
+	}
+}
+
+func TestCompareRemotes(t *testing.T) {
+	primary, mirror := newTestServer(t), newTestServer(t)
+	primary.dirs["data"] = []string{
+		"type=file;size=1;modify=20150813224845; same",
+		"type=file;size=2;modify=20150813224845; resized",
+		"type=file;size=3;modify=20150813224845; missing",
+	}
+	mirror.dirs["data"] = []string{
+		"type=file;size=1;modify=20150813224845; same",
+		"type=file;size=5;modify=20150813224845; resized",
+		"type=file;size=4;modify=20150813224845; extra",
+	}
+
+	comparisons, err := CompareRemotes(primary.connect(), mirror.connect(), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]CompareResult{
+		"extra":   CompareOnlyMirror,
+		"missing": CompareOnlyPrimary,
+		"resized": CompareSizeDiffers,
+		"same":    CompareSame,
+	}
+	if len(comparisons) != len(want) {
+		t.Fatalf("got %d comparisons, want %d", len(comparisons), len(want))
+	}
+	for _, c := range comparisons {
+		if c.Result != want[c.Path] {
+			t.Errorf("%s: got result %d, want %d", c.Path, c.Result, want[c.Path])
+		}
+	}
+}
+
+func TestFetcher(t *testing.T) {
+	s := newTestServer(t)
+	var f Fetcher
+	url := "ftp://user:secret@" + s.Addr() + "/dir/file"
+
+	if err := f.Put(url, strings.NewReader("fetched")); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	stored := string(s.files["dir/file"])
+	s.mu.Unlock()
+	if stored != "fetched" {
+		t.Errorf("stored %q", stored)
+	}
+
+	r, err := f.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(content) != "fetched" {
+		t.Errorf("got %q, %v", content, err)
+	}
+
+	// Errors of the transfer are returned by the reader
+	if r, err = f.Get("ftp://" + s.Addr() + "/missing"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadAll(r); err == nil {
+		t.Error("missing file read without error")
+	}
+	r.Close()
+
+	if _, err = f.Get("http://" + s.Addr() + "/file"); err == nil {
+		t.Error("http URL accepted")
+	}
+}
+
+func TestUploadWithManifest(t *testing.T) {
+	s := newTestServer(t)
+	ftp := s.connect()
+
+	dir := t.TempDir()
+	local := filepath.Join(dir, "report.csv")
+	if err := os.WriteFile(local, []byte("a,b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ftp.UploadWithManifest([]string{local, filepath.Join(dir, "missing")})
+	var batch *BatchError
+	if !errors.As(err, &batch) || len(batch.Errors) != 1 {
+		t.Fatalf("got %v, want one failed path", err)
+	}
+	if len(m.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(m.Entries))
+	}
+
+	sum := sha256.Sum256([]byte("a,b\n"))
+	e := m.Entries[0]
+	if e.Path != "report.csv" || e.Size != 4 || e.Code != StatusClosingDataConnection ||
+		e.SHA256 != hex.EncodeToString(sum[:]) || e.Error != "" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
+
+func TestPassivePortRange(t *testing.T) {
+	s := newTestServer(t)
+	ftp := s.connect()
+
+	ftp.SetPassivePortRange(1, 1)
+	if _, err := ftp.Pasv(); !errors.Is(err, ErrPassivePort) {
+		t.Errorf("got %v, want ErrPassivePort", err)
+	}
+
+	ftp.SetPassivePortRange(1, 65535)
+	if _, err := ftp.Pasv(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRequireTLS(t *testing.T) {
+	s := newTestServer(t)
+
+	// The test server does not implement AUTH
+	if _, err := ConnectTLS(s.Addr(), &tls.Config{}); !errors.Is(err, ErrTLSRequired) {
+		t.Errorf("ConnectTLS gave %v, want ErrTLSRequired", err)
+	}
+
+	ftp, err := Connect(s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ftp.Close()
+
+	ftp.SetRequireTLS(true)
+	if err = ftp.Login("user", "secret"); !errors.Is(err, ErrTLSRequired) {
+		t.Errorf("Login gave %v, want ErrTLSRequired", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, verb := range s.verbs {
+		if verb == "USER" || verb == "PASS" {
+			t.Errorf("%s sent in plaintext", verb)
+		}
+	}
+}
+
+func TestLoginTimeout(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["PASS"] = ""
+
+	ftp, err := Connect(s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ftp.Close()
+
+	ftp.SetAuthTimeout(50 * time.Millisecond)
+	if err = ftp.Login("user", "secret"); err != ErrAuthTimeout {
+		t.Errorf("got %v, want ErrAuthTimeout", err)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["PASS"] = "530 Login incorrect."
+
+	ftp, err := Connect(s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ftp.Close()
+
+	err = ftp.Login("user", "wrong")
+	if !errors.Is(err, ErrAuthFailed) || replyCode(err) != 530 {
+		t.Errorf("got %v, want ErrAuthFailed with the 530 reply", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["FEAT"] = "211-Features:\r\n MDTM\r\n HASH SHA-1*;SHA-256;MD5\r\n211 End"
+	s.replies["OPTS"] = "200 SHA-256"
+	s.replies["HASH"] = "213 SHA-256 0-3 9f86d081884c7d65 file"
+	ftp := s.connect()
+
+	algo, digest, err := ftp.Checksum("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if algo != "SHA-256" || digest != "9f86d081884c7d65" {
+		t.Errorf("got %s %s", algo, digest)
+	}
+	if _, current, _ := ftp.HashAlgorithms(); current != "SHA-256" {
+		t.Errorf("selected algorithm is %s, want SHA-256", current)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["FEAT"] = "211-Features:\r\n LANG EN*;FR;DE\r\n211 End"
+	s.replies["LANG"] = "200 Réponses en français"
+	ftp := s.connect()
+
+	if err := ftp.SetLanguage("FR"); err != nil {
+		t.Fatal(err)
+	}
+	languages, current, err := ftp.Languages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(languages, ",") != "EN,FR,DE" || current != "FR" {
+		t.Errorf("got %v, current %s", languages, current)
+	}
+}
+
+func TestPret(t *testing.T) {
+	for feat, want := range map[string]bool{
+		"211-Features:\r\n PRET\r\n211 End": true,
+		"211-Features:\r\n MDTM\r\n211 End": false,
+		"550 not now":                       false,
+	} {
+		s := newTestServer(t)
+		s.replies["FEAT"] = feat
+		s.replies["PRET"] = "200 OK, will use node 1"
+		s.files["file"] = []byte("content")
+		ftp := s.connect()
+
+		for i := 0; i < 2; i++ {
+			if _, err := ftp.RetrTo("file", io.Discard); err != nil {
+				t.Fatalf("%q: %v", feat, err)
+			}
+		}
+		if n := s.countVerb("FEAT", 0); n != 1 {
+			t.Errorf("%q: FEAT sent %d times, want once", feat, n)
+		}
+
+		s.mu.Lock()
+		verbs := strings.Join(s.verbs, " ")
+		s.mu.Unlock()
+		if sent := strings.Contains(verbs, "PRET PASV RETR"); sent != want {
+			t.Errorf("%q: PRET sent %v, want %v: %s", feat, sent, want, verbs)
+		}
+	}
+}
+
+func TestRawFeatures(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["FEAT"] = "211-Features:\r\n SITE SYMLINK\r\n SITE UTIME\r\n MFF modify;UNIX.mode;\r\n211 End"
+	ftp := s.connect()
+
+	raw, err := ftp.RawFeatures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"SITE SYMLINK", "SITE UTIME", "MFF modify;UNIX.mode;"}
+	if strings.Join(raw, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", raw, want)
+	}
+}
+
+func TestSetFacts(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["FEAT"] = "211-Features:\r\n MFF modify;UNIX.mode;\r\n211 End"
+	s.replies["MFF"] = "213 modify=20150813224845;UNIX.mode=0644; file"
+	ftp := s.connect()
+
+	if err := ftp.SetFacts("file", map[string]string{"modify": "20150813224845", "UNIX.mode": "0644"}); err != nil {
+		t.Error(err)
+	}
+	if err := ftp.SetFacts("file", map[string]string{"UNIX.owner": "root"}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("got %v, want ErrNotSupported", err)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	s := newTestServer(t)
+	s.dirs["tree"] = []string{"type=file;size=1; a", "type=dir; sub"}
+	s.dirs["tree/sub"] = []string{"type=file;size=1; b"}
+	s.files["tree/a"] = []byte("a")
+	s.files["tree/sub/b"] = []byte("b")
+	ftp := s.connect()
+
+	// SITE is not implemented by the test server
+	if err := ftp.RemoveAll("tree"); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	if len(s.files) != 0 || len(s.dirs) != 0 {
+		t.Errorf("left files %v and dirs %v", s.files, s.dirs)
+	}
+	s.dirs["other"] = nil
+	s.mu.Unlock()
+
+	if err := ftp.RemoveAll("other"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sites := 0
+	for _, verb := range s.verbs {
+		if verb == "SITE" {
+			sites++
+		}
+	}
+	if sites != 1 {
+		t.Errorf("SITE RMDIR sent %d times, want once", sites)
+	}
+}
+
+func TestWalkEntries(t *testing.T) {
+	s := newTestServer(t)
+	s.dirs["root"] = []string{"type=dir; skipped", "type=file;size=3; a", "type=dir; sub", "type=file;size=1; z"}
+	s.dirs["root/skipped"] = []string{"type=file;size=1; hidden"}
+	s.dirs["root/sub"] = []string{"type=file;size=2; b", "type=file;size=2; c"}
+	ftp := s.connect()
+
+	var visited []string
+	err := ftp.WalkEntries("root", func(path string, e *Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, fmt.Sprintf("%s:%d", path, e.Size))
+		switch path {
+		case "root/skipped", "root/sub/b":
+			return filepath.SkipDir
+		case "root/z":
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "root/skipped:0 root/a:3 root/sub:0 root/sub/b:2 root/z:1"
+	if got := strings.Join(visited, " "); got != want {
+		t.Errorf("visited %s, want %s", got, want)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["FEAT"] = "211-Features:\r\n MDTM\r\n AUTH TLS\r\n REST STREAM\r\n211 End"
+	ftp := s.connect()
+
+	if err := ftp.Preflight(FeatureMDTM, FeatureTLS, FeatureREST); err != nil {
+		t.Error(err)
+	}
+
+	err := ftp.Preflight(FeatureMFMT, FeatureMDTM, FeatureMLST)
+	var preflight *PreflightError
+	if !errors.As(err, &preflight) || !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("got %v, want a PreflightError", err)
+	}
+	if err.Error() != "server lacks MFMT and MLST" {
+		t.Errorf("unexpected message %q", err)
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	s := newTestServer(t)
+	open := func() (*FTP, error) {
+		ftp, err := Connect(s.Addr())
+		if err != nil {
+			return nil, err
+		}
+		return ftp, ftp.Login("anonymous", "anonymous")
+	}
+
+	if _, err := Benchmark(open, "", 1000, 3, 4); err == nil {
+		t.Error("benchmark ran without a path")
+	}
+
+	result, err := Benchmark(open, "bench", 1000, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stats := range []ThroughputStats{result.Upload, result.Download} {
+		if stats.Transfers != 12 || stats.Bytes != 12000 || stats.P50 <= 0 || stats.P99 < stats.P50 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	}
+
+	s.mu.Lock()
+	if len(s.files) != 0 {
+		t.Errorf("benchmark files left: %d", len(s.files))
+	}
+	// Uploaded files are removed when the downloads fail
+	s.replies["RETR"] = "550 no access"
+	s.mu.Unlock()
+	if _, err := Benchmark(open, "bench", 1000, 2, 1); replyCode(err) != StatusFileUnavailable {
+		t.Errorf("got %v, want a 550 reply", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) != 0 {
+		t.Errorf("files left after a failure: %d", len(s.files))
+	}
+}
+
+func TestDownloadDir(t *testing.T) {
+	s := newTestServer(t)
+	s.dirs["remote"] = []string{"type=file;size=3; new.txt", "type=file;size=3; kept.txt", "type=dir; sub"}
+	s.dirs["remote/sub"] = []string{"type=file;size=3; deep.txt"}
+	s.files["remote/new.txt"] = []byte("new")
+	s.files["remote/kept.txt"] = []byte("new")
+	s.files["remote/sub/deep.txt"] = []byte("new")
+	ftp := s.connect()
+
+	local := t.TempDir()
+	if err := os.WriteFile(filepath.Join(local, "kept.txt"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ftp.DownloadDir("remote", local, CollisionError); !errors.Is(err, os.ErrExist) {
+		t.Errorf("got %v, want os.ErrExist", err)
+	}
+
+	results, err := ftp.DownloadDir("remote", local, CollisionRename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcomes := map[string]DownloadOutcome{}
+	for _, r := range results {
+		outcomes[filepath.Base(r.LocalPath)] = r.Outcome
+	}
+	want := map[string]DownloadOutcome{"new-1.txt": DownloadRenamed, "kept-1.txt": DownloadRenamed, "deep.txt": Downloaded}
+	if fmt.Sprint(outcomes) != fmt.Sprint(want) {
+		t.Errorf("got outcomes %v, want %v", outcomes, want)
+	}
+	if kept, _ := os.ReadFile(filepath.Join(local, "kept.txt")); string(kept) != "old" {
+		t.Errorf("local file overwritten with %q", kept)
+	}
+}
+
+func TestDownloadDirUnsafeName(t *testing.T) {
+	s := newTestServer(t)
+	s.dirs["remote"] = []string{"type=file;size=2; ok.txt", "type=file;size=4; ../escaped.txt"}
+	s.files["remote/ok.txt"] = []byte("ok")
+	s.files["remote/../escaped.txt"] = []byte("evil")
+	ftp := s.connect()
+
+	parent := t.TempDir()
+	local := filepath.Join(parent, "local")
+	if _, err := ftp.DownloadDir("remote", local, CollisionOverwrite); !errors.Is(err, ErrUnsafeName) {
+		t.Errorf("got %v, want ErrUnsafeName", err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped.txt")); !os.IsNotExist(err) {
+		t.Error("file written outside the local folder")
+	}
+	if _, err := os.Stat(filepath.Join(local, "ok.txt")); !os.IsNotExist(err) {
+		t.Error("download started despite an unsafe name")
+	}
+}
+
+func TestDownloadDirFailedOverwrite(t *testing.T) {
+	s := newTestServer(t)
+	s.dirs["remote"] = []string{"type=file;size=3; kept.txt"}
+	s.replies["RETR"] = "550 gone"
+	ftp := s.connect()
+
+	local := t.TempDir()
+	if err := os.WriteFile(filepath.Join(local, "kept.txt"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ftp.DownloadDir("remote", local, CollisionOverwrite); err == nil {
+		t.Fatal("download of a missing file succeeded")
+	}
+	if kept, _ := os.ReadFile(filepath.Join(local, "kept.txt")); string(kept) != "old" {
+		t.Errorf("local file replaced with %q", kept)
+	}
+	if names, _ := os.ReadDir(local); len(names) != 1 {
+		t.Errorf("temporary files left: %v", names)
+	}
+}
+
+func TestMappedUploads(t *testing.T) {
+	s := newTestServer(t)
+	ftp := s.connect()
+	ftp.SetMappedUploads(true)
+
+	local := filepath.Join(t.TempDir(), "mapped")
+	if err := os.WriteFile(local, []byte("mapped content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ftp.Upload(local); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if got := string(s.files["mapped"]); got != "mapped content" {
+		t.Errorf("uploaded %q", got)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	s := newTestServer(t)
+	s.files["big"] = bytes.Repeat([]byte("x"), 1<<22)
+	ftp := s.connect()
+
+	if ftp.Abort("nothing to cancel") {
+		t.Error("Abort without a transfer")
+	}
+
+	_, err := ftp.Retr("big", func(r io.Reader) error {
+		if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+			return err
+		}
+		done := make(chan bool)
+		go func() { done <- ftp.Abort("stalled") }()
+		if !<-done {
+			t.Error("Abort found no transfer")
+		}
+		_, err := io.Copy(io.Discard, r)
+		return err
+	})
+
+	var aborted *AbortError
+	if !errors.As(err, &aborted) || !errors.Is(err, ErrAborted) {
+		t.Fatalf("got %v, want an AbortError", err)
+	}
+	if aborted.Reason != "stalled" || aborted.Bytes < 10 {
+		t.Errorf("unexpected %+v", aborted)
+	}
+	if err = ftp.Noop(); err != nil {
+		t.Errorf("session unusable after abort: %v", err)
+	}
+}
+
+func TestListLazySpill(t *testing.T) {
+	s := newTestServer(t)
+	for i := 0; i < 100; i++ {
+		s.dirs["huge"] = append(s.dirs["huge"], fmt.Sprintf("type=file;size=%d; f%d", i, i))
+	}
+	ftp := s.connect()
+	ftp.SetListSpillThreshold(10)
+
+	listing, err := ftp.ListLazy("huge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listing.file == nil {
+		t.Error("listing was not spilled")
+	}
+
+	var n uint64
+	for {
+		e, err := listing.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if e.Size != n || e.Name != fmt.Sprintf("f%d", n) {
+			t.Fatalf("unexpected entry %+v", e)
+		}
+		n++
+	}
+	if n != 100 || listing.Len() != 100 {
+		t.Errorf("got %d entries of %d lines, want 100", n, listing.Len())
+	}
+
+	name := listing.file.Name()
+	if err = listing.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Error("temporary file left behind")
+	}
+}
+
+func TestRename(t *testing.T) {
+	s := newTestServer(t)
+	s.files["a"] = []byte("a")
+	// The server loses the RNFR state on a transient error
+	s.once["RNTO"] = "451 try again"
+	ftp := s.connect()
+
+	if err := ftp.Rename("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	_, renamed := s.files["b"]
+	s.mu.Unlock()
+	if !renamed {
+		t.Error("file not renamed")
+	}
+
+	err := ftp.Rename("missing", "c")
+	var rerr *RenameError
+	if !errors.As(err, &rerr) || rerr.Command != "RNFR" || replyCode(err) != StatusFileUnavailable {
+		t.Errorf("got %v, want a RNFR RenameError", err)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["ALLO"] = "552 quota exceeded"
+	ftp := s.connect()
+	ftp.SetAllocate(true)
+
+	if err := ftp.Stor("big", bytes.NewReader(make([]byte, 100))); replyCode(err) != StatusExceededStorage {
+		t.Errorf("got %v, want the ALLO rejection", err)
+	}
+
+	// Not implemented by the server: uploads proceed
+	s.mu.Lock()
+	delete(s.replies, "ALLO")
+	s.mu.Unlock()
+	if err := ftp.Stor("big", bytes.NewReader(make([]byte, 100))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ftp.Stor("big", bytes.NewReader(make([]byte, 100))); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	allos := 0
+	for _, verb := range s.verbs {
+		if verb == "ALLO" {
+			allos++
+		}
+	}
+	if allos != 2 {
+		t.Errorf("ALLO sent %d times, want 2", allos)
+	}
+}
+
+func TestListRecursive(t *testing.T) {
+	s := newTestServer(t)
+	s.dirs["-R tree"] = []string{
+		".:",
+		"total 8",
+		"drwxr-xr-x   2 ftp ftp         4096 Jun 10  1994 sub",
+		"-rw-r--r--   1 ftp ftp            1 Jun 10  1994 a",
+		"",
+		"./sub:",
+		"-rw-r--r--   1 ftp ftp            2 Jun 10  1994 b",
+	}
+	// Without LIST -R support, the tree is walked with MLSD
+	s.dirs["walked"] = []string{"type=dir; sub", "type=file;size=1; a"}
+	s.dirs["walked/sub"] = []string{"type=file;size=2; b"}
+	ftp := s.connect()
+
+	for _, root := range []string{"tree", "walked"} {
+		entries, err := ftp.ListRecursive(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		sort.Strings(names)
+		want := root + "/a " + root + "/sub " + root + "/sub/b"
+		if got := strings.Join(names, " "); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestSessionSnapshot(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["FEAT"] = "211-Features:\r\n MDTM\r\n211 End"
+	ftp := s.connect()
+	ftp.SetLenientList(true)
+	ftp.SetPassivePortRange(1024, 65535)
+	ftp.SetDataMode(ActiveMode)
+	ftp.SetActivePortRange(40000, 40100)
+	ftp.SetActiveAddress("192.0.2.1")
+	if _, err := ftp.Features(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := ftp.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SessionSnapshot
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := RestoreSession(&decoded, StaticCredentials{"anonymous", "anonymous"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	if !restored.lenientList || restored.passiveMax != 65535 {
+		t.Error("options not restored")
+	}
+	if restored.dataMode != ActiveMode || restored.activeMax != 40100 || restored.activeAddr != "192.0.2.1" {
+		t.Error("active mode settings not restored")
+	}
+	if _, ok := restored.features["MDTM"]; !ok {
+		t.Error("features not restored")
+	}
+}
+
+// memoryStore is a ResumeStore keeping states in memory
+type memoryStore map[string]*TransferState
+
+func (m memoryStore) LoadState(key string) (*TransferState, error) { return m[key], nil }
+
+func (m memoryStore) SaveState(key string, state *TransferState) error {
+	m[key] = state
+	return nil
+}
+
+func (m memoryStore) DeleteState(key string) error {
+	delete(m, key)
+	return nil
+}
+
+// failingReader returns an error once its limit is read
+type failingReader struct {
+	r     io.Reader
+	limit int
+}
+
+func (f *failingReader) Read(b []byte) (int, error) {
+	if f.limit == 0 {
+		return 0, errors.New("connection lost")
+	}
+	if len(b) > f.limit {
+		b = b[:f.limit]
+	}
+	n, err := f.r.Read(b)
+	f.limit -= n
+	return n, err
+}
+
+func (f *failingReader) Seek(offset int64, whence int) (int64, error) {
+	return f.r.(io.Seeker).Seek(offset, whence)
+}
+
+// countVerb returns how many times verb was received since the n-th command
+func (s *testServer) countVerb(verb string, n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, v := range s.verbs[n:] {
+		if v == verb {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *testServer) commands() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.verbs)
+}
+
+func TestRest(t *testing.T) {
+	s := newTestServer(t)
+	s.files["file"] = []byte("0123456789")
+	ftp := s.connect()
+
+	var buf bytes.Buffer
+	err := ftp.RetrFrom("file", 4, func(r io.Reader) error {
+		_, err := io.Copy(&buf, r)
+		return err
+	})
+	if err != nil || buf.String() != "456789" {
+		t.Fatalf("got %q, %v", buf.String(), err)
+	}
+	if stats := ftp.LastTransfer(); !stats.Restarted || stats.Offset != 4 {
+		t.Errorf("restarted download has stats %+v", stats)
+	}
+	if err = ftp.StorFrom("file", strings.NewReader("abc"), 7); err != nil {
+		t.Fatal(err)
+	}
+	if stats := ftp.LastTransfer(); !stats.Restarted || stats.Offset != 7 {
+		t.Errorf("restarted upload has stats %+v", stats)
+	}
+	s.mu.Lock()
+	content := string(s.files["file"])
+	s.mu.Unlock()
+	if content != "0123456abc" {
+		t.Errorf("restarted upload gave %q", content)
+	}
+
+	s.mu.Lock()
+	s.once["REST"] = "350 Restarting at 0"
+	s.mu.Unlock()
+	if err = ftp.Rest(5); !errors.Is(err, ErrRestartNotSupported) {
+		t.Errorf("other effective offset gave %v, want ErrRestartNotSupported", err)
+	}
+	s.mu.Lock()
+	s.once["REST"] = "502 REST not implemented"
+	s.mu.Unlock()
+	if err = ftp.Rest(5); !errors.Is(err, ErrRestartNotSupported) {
+		t.Errorf("refused REST gave %v, want ErrRestartNotSupported", err)
+	}
+
+	if err = ftp.Type(TypeASCII); err != nil {
+		t.Fatal(err)
+	}
+	if err = ftp.Rest(5); err != ErrRestartASCII {
+		t.Errorf("REST in ASCII mode gave %v, want ErrRestartASCII", err)
+	}
+	if n := s.countVerb("REST", 0); n != 4 {
+		t.Errorf("REST sent %d times, want 4", n)
+	}
+}
+
+func TestStorResumable(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	for _, c := range []struct {
+		name    string
+		local   []byte // content of the second attempt
+		restRep string // reply to REST, if overridden
+		resumed bool
+	}{
+		{"resumed", content, "", true},
+		{"local content changed", []byte("9876543210abcdefghij"), "", false},
+		{"REST refused", content, "502 REST not implemented", false},
+	} {
+		s := newTestServer(t)
+		store := memoryStore{}
+
+		interrupted := &failingReader{r: bytes.NewReader(content), limit: 8}
+		if err := s.connect().StorResumable("file", interrupted, int64(len(content)), store); err == nil {
+			t.Fatalf("%s: interrupted upload succeeded", c.name)
+		}
+		if len(store) != 1 {
+			t.Fatalf("%s: %d states saved, want 1", c.name, len(store))
+		}
+
+		if c.restRep != "" {
+			s.mu.Lock()
+			s.replies["REST"] = c.restRep
+			s.mu.Unlock()
+		}
+		// A new session, which the server starts in ASCII mode
+		n := s.commands()
+		ftp := s.connect()
+		if err := ftp.StorResumable("file", bytes.NewReader(c.local), int64(len(c.local)), store); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if restarted := ftp.LastTransfer().Restarted; restarted != c.resumed {
+			t.Errorf("%s: Restarted is %v, want %v", c.name, restarted, c.resumed)
+		}
+
+		s.mu.Lock()
+		uploaded := string(s.files["file"])
+		s.mu.Unlock()
+		if uploaded != string(c.local) {
+			t.Errorf("%s: server has %q, want %q", c.name, uploaded, c.local)
+		}
+		if resumed := s.countVerb("REST", n) == 1 && c.restRep == ""; resumed != c.resumed {
+			t.Errorf("%s: resumed %v, want %v", c.name, resumed, c.resumed)
+		}
+		if len(store) != 0 {
+			t.Errorf("%s: state left after the upload", c.name)
+		}
+	}
+}
+
+func TestRetrResumable(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	for _, c := range []struct {
+		name    string
+		partial []byte // left locally by the interrupted download
+		restRep string
+		resumed bool
+	}{
+		{"resumed", content[:8], "", true},
+		{"local content changed", []byte("XXXXXXXX"), "", false},
+		{"REST refused", content[:8], "502 REST not implemented", false},
+	} {
+		s := newTestServer(t)
+		s.dirs["dir"] = nil
+		s.files["file"] = content
+		if c.restRep != "" {
+			s.replies["REST"] = c.restRep
+		}
+		ftp := s.connect()
+
+		// State as left by a download interrupted after 8 bytes
+		sum := sha256.Sum256(content[:8])
+		store := memoryStore{"RETR " + s.Addr() + " file": {
+			RemotePath: "file",
+			Size:       int64(len(content)),
+			Offset:     8,
+			PrefixHash: sum[:],
+		}}
+		local := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(local, c.partial, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(local, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		// Leaves the session in ASCII mode, where SIZE may be refused
+		if _, err = ftp.List("dir"); err != nil {
+			t.Fatal(err)
+		}
+		if err = ftp.RetrResumable("file", f, store); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if downloaded, _ := os.ReadFile(local); string(downloaded) != string(content) {
+			t.Errorf("%s: got %q", c.name, downloaded)
+		}
+		if resumed := s.countVerb("REST", 0) == 1 && c.restRep == ""; resumed != c.resumed {
+			t.Errorf("%s: resumed %v, want %v", c.name, resumed, c.resumed)
+		}
+		if restarted := ftp.LastTransfer().Restarted; restarted != c.resumed {
+			t.Errorf("%s: Restarted is %v, want %v", c.name, restarted, c.resumed)
+		}
+		if len(store) != 0 {
+			t.Errorf("%s: state left after the download", c.name)
+		}
+	}
+}
+
+func TestSessionSnapshotLocation(t *testing.T) {
+	s := newTestServer(t)
+	ftp := s.connect()
+
+	for _, loc := range []*time.Location{
+		time.FixedZone("EST", -5*3600),
+		time.FixedZone("", 5*3600+1800),
+		time.FixedZone("XYZ", 3600),
+	} {
+		ftp.SetLocation(loc)
+		snapshot, err := ftp.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored, err := RestoreSession(snapshot, StaticCredentials{"anonymous", "anonymous"}, nil)
+		if err != nil {
+			t.Fatalf("%q: %v", loc, err)
+		}
+		restored.Close()
+
+		now := time.Now()
+		_, want := now.In(loc).Zone()
+		if _, got := now.In(restored.location).Zone(); got != want {
+			t.Errorf("%q: restored offset %d, want %d", loc, got, want)
+		}
+	}
+}
+
+func TestContext(t *testing.T) {
+	s := newTestServer(t)
+	s.files["file"] = []byte("content")
+	s.replies["SIZE"] = ""
+
+	ftp, err := ConnectContext(context.Background(), s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ftp.Close()
+	if err = ftp.LoginContext(context.Background(), "anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = ftp.RetrContext(context.Background(), "file", func(r io.Reader) error {
+		_, err := io.Copy(&buf, r)
+		return err
+	})
+	if err != nil || buf.String() != "content" {
+		t.Fatalf("got %q, %v", buf.String(), err)
+	}
+
+	// The server never answers SIZE
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = ftp.RunContext(ctx, func() error {
+		_, err := ftp.Size("file")
+		return err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestWalkContext(t *testing.T) {
+	s := newTestServer(t)
+	s.dirs["dir/"] = []string{"type=file;size=1; a", "type=dir; sub"}
+	s.dirs["dir/sub/"] = []string{"type=file;size=1; b"}
+	ftp := s.connect()
+
+	var walked []string
+	err := ftp.WalkContext(context.Background(), "dir/", func(path string, info os.FileMode, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(walked, " "); got != "dir/a dir/sub/b" {
+		t.Errorf("walked %s", got)
+	}
+}
+
+func TestContextCancelKeptByLogin(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["PASS"] = ""
+
+	ftp, err := Connect(s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ftp.Close()
+	ftp.SetAuthTimeout(5 * time.Second)
+
+	// The auth timeout of the login commands must not lift the cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	err = ftp.RunContext(ctx, func() error {
+		cancel()
+		time.Sleep(20 * time.Millisecond)
+		return ftp.Login("anonymous", "anonymous")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled login returned after %v", elapsed)
+	}
+}
+
+func TestActiveMode(t *testing.T) {
+	s := newTestServer(t)
+	s.files["file"] = []byte("content")
+	s.dirs["dir"] = []string{"type=file;size=7; file"}
+	ftp := s.connect()
+	ftp.SetDataMode(ActiveMode)
+	ftp.SetActivePortRange(40000, 40100)
+
+	var buf bytes.Buffer
+	if _, err := ftp.RetrTo("file", &buf); err != nil || buf.String() != "content" {
+		t.Fatalf("got %q, %v", buf.String(), err)
+	}
+	if port := ftp.LastTransfer().LocalAddr.(*net.TCPAddr).Port; port < 40000 || port > 40100 {
+		t.Errorf("listened on port %d, outside the range", port)
+	}
+
+	// Passive for a single listing
+	err := ftp.WithDataMode(PassiveMode, func() error {
+		_, err := ftp.List("dir")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	verbs := strings.Join(s.verbs, " ")
+	if !strings.Contains(verbs, "PORT RETR") || !strings.Contains(verbs, "PASV MLSD") {
+		t.Errorf("unexpected commands %s", verbs)
+	}
+}
+
+func TestEncryptedTransfer(t *testing.T) {
+	s := newTestServer(t)
+	ftp := s.connect()
+	key := bytes.Repeat([]byte{7}, 32)
+	plain := bytes.Repeat([]byte("secret "), encChunkSize/3)
+
+	for _, path := range []string{"a", "b"} {
+		if err := ftp.StorEncrypted(path, bytes.NewReader(plain), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.mu.Lock()
+	a, b := s.files["a"], s.files["b"]
+	s.mu.Unlock()
+	if a[0] != encVersion || bytes.Contains(a, []byte("secret")) {
+		t.Error("content not encrypted")
+	}
+	if bytes.Equal(a[:encHeaderSize], b[:encHeaderSize]) {
+		t.Error("salt reused")
+	}
+
+	var got bytes.Buffer
+	err := ftp.RetrEncrypted("a", key, func(r io.Reader) error {
+		_, err := io.Copy(&got, r)
+		return err
+	})
+	if err != nil || !bytes.Equal(got.Bytes(), plain) {
+		t.Fatalf("round trip failed: %v", err)
+	}
+
+	wrong := bytes.Repeat([]byte{8}, 32)
+	err = ftp.RetrEncrypted("b", wrong, func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	})
+	if !errors.Is(err, ErrDecrypt) {
+		t.Errorf("wrong key gave %v, want ErrDecrypt", err)
+	}
+}
+
+func TestCompressedTransfer(t *testing.T) {
+	s := newTestServer(t)
+	ftp := s.connect()
+	plain := strings.Repeat("compressible ", 1000)
+
+	if err := ftp.StorCompressed("log", strings.NewReader(plain), Gzip); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	stored := s.files["log.gz"]
+	s.mu.Unlock()
+	if len(stored) < 2 || stored[0] != 0x1f || stored[1] != 0x8b || len(stored) >= len(plain) {
+		t.Fatalf("stored %d bytes, not gzip compressed", len(stored))
+	}
+
+	var got bytes.Buffer
+	err := ftp.RetrCompressed("log", Gzip, func(r io.Reader) error {
+		_, err := io.Copy(&got, r)
+		return err
+	})
+	if err != nil || got.String() != plain {
+		t.Errorf("round trip failed: %v", err)
+	}
+}
+
+func TestActiveModeRejectsForeignHost(t *testing.T) {
+	s := newTestServer(t)
+	s.foreignFirst = true
+	s.files["file"] = []byte("content")
+	ftp := s.connect()
+	ftp.SetDataMode(ActiveMode)
+
+	var buf bytes.Buffer
+	if _, err := ftp.RetrTo("file", &buf); err != nil || buf.String() != "content" {
+		t.Fatalf("got %q, %v", buf.String(), err)
+	}
+}
+
+func TestRetrTo(t *testing.T) {
+	s := newTestServer(t)
+	s.files["file"] = []byte("test")
+	ftp := s.connect()
+
+	var buf bytes.Buffer
+	h := sha256.New()
+	n, err := ftp.RetrTo("file", &buf, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 || buf.String() != "test" {
+		t.Errorf("got %d bytes %q", n, buf.String())
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.HasPrefix(sum, "9f86d081884c7d65") {
+		t.Errorf("unexpected hash %s", sum)
+	}
+
+	stats := ftp.LastTransfer()
+	if stats == nil || stats.Command != "RETR" || stats.Bytes != 4 || stats.RemoteAddr == nil {
+		t.Errorf("unexpected transfer stats %+v", stats)
+	}
+}
+
+func TestSessionCache(t *testing.T) {
+	s := newTestServer(t)
+	cache := NewSessionCache(time.Minute)
+	defer cache.Close()
+
+	first, err := cache.Get(s.Addr(), "anonymous", "anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put(first)
+
+	second, err := cache.Get(s.Addr(), "anonymous", "anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Error("idle session was not reused")
+	}
+
+	other, err := cache.Get(s.Addr(), "someone", "else")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Error("session reused for other credentials")
+	}
+	cache.Put(second)
+	cache.Put(other)
+}
+
+func TestSessionCacheWarmUp(t *testing.T) {
+	s := newTestServer(t)
+	cache := NewSessionCache(time.Minute)
+	cache.SetWarmUp(50 * time.Millisecond)
+	defer cache.Close()
+
+	first, err := cache.Get(s.Addr(), "anonymous", "anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Put(first)
+
+	second, err := cache.Get(s.Addr(), "anonymous", "anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Fatal("idle session was not reused")
+	}
+	cache.Put(second)
+
+	// A session which no longer answers is replaced
+	s.mu.Lock()
+	s.replies["NOOP"] = ""
+	s.mu.Unlock()
+	third, err := cache.Get(s.Addr(), "anonymous", "anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first {
+		t.Error("unresponsive session was reused")
+	}
+	cache.Put(third)
+}
+
+func TestLock(t *testing.T) {
+	s := newTestServer(t)
+	ftp := s.connect()
+
+	if err := ftp.Lock("drop/file", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := ftp.Lock("drop/file", time.Hour); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Lock gave %v, want ErrLocked", err)
+	}
+	if err := ftp.Unlock("drop/file"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stale marker is taken over
+	s.mu.Lock()
+	s.files["other.lock"] = nil
+	s.modTimes["other.lock"] = time.Now().Add(-2 * time.Hour)
+	s.mu.Unlock()
+	if err := ftp.StorLocked("other", strings.NewReader("data"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	_, locked := s.files["other.lock"]
+	s.mu.Unlock()
+	if locked {
+		t.Error("lock not released after StorLocked")
+	}
+}
+
+func TestLockWithoutMdtm(t *testing.T) {
+	s := newTestServer(t)
+	s.replies["MDTM"] = "502 not implemented"
+	ftp := s.connect()
+
+	if err := ftp.Lock("drop/file", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := ftp.Lock("drop/file", time.Hour); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Lock gave %v, want ErrLocked", err)
+	}
+
+	// The age of the marker is read from its content
+	s.mu.Lock()
+	s.files["drop/file.lock"] = []byte("crashed 42 " + time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339) + "\n")
+	s.mu.Unlock()
+	if err := ftp.Lock("drop/file", time.Hour); err != nil {
+		t.Fatalf("stale marker not replaced: %v", err)
+	}
+	s.mu.Lock()
+	owner := string(s.files["drop/file.lock"])
+	s.mu.Unlock()
+	if strings.HasPrefix(owner, "crashed") {
+		t.Errorf("marker still %q", owner)
+	}
+
+	s.mu.Lock()
+	s.files["drop/file.lock"] = []byte("garbage")
+	s.mu.Unlock()
+	if err := ftp.Lock("drop/file", time.Hour); err == nil {
+		t.Error("unreadable marker replaced")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s := newTestServer(t)
+	s.files["file"] = []byte("test")
+	ftp := s.connect()
+	ftp.SetReadOnly(true)
+
+	if err := ftp.Dele("file"); err != ErrReadOnly {
+		t.Errorf("Dele gave %v, want ErrReadOnly", err)
+	}
+	if err := ftp.Stor("new", strings.NewReader("data")); err != ErrReadOnly {
+		t.Errorf("Stor gave %v, want ErrReadOnly", err)
+	}
+	if err := ftp.RemoveAll("dir"); err != ErrReadOnly {
+		t.Errorf("RemoveAll gave %v, want ErrReadOnly", err)
+	}
+	// Refused before preparing the transfer
+	if n := s.countVerb("PASV", 0) + s.countVerb("TYPE", 0) + s.countVerb("MLSD", 0); n != 0 {
+		t.Errorf("%d commands sent for refused operations", n)
+	}
+	if err := ftp.Chmod("file", 0600); err != ErrReadOnly {
+		t.Errorf("Chmod gave %v, want ErrReadOnly", err)
+	}
+	if _, err := ftp.RetrTo("file", io.Discard); err != nil {
+		t.Errorf("Retr in read-only session: %v", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	s := newTestServer(t)
+	s.files["file"] = []byte("test")
+	var logged bytes.Buffer
+	d := NewDryRun(s.connect(), log.New(&logged, "", 0))
+
+	if err := d.Stor("new", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Rename("file", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Dele("file"); err != nil {
+		t.Fatal(err)
+	}
+	want := "dry-run: STOR new\ndry-run: RNFR file\ndry-run: RNTO renamed\ndry-run: DELE file\n"
+	if logged.String() != want {
+		t.Errorf("logged %q, want %q", logged.String(), want)
+	}
+
+	// Reads go to the server, other changes are refused
+	var buf bytes.Buffer
+	if _, err := d.RetrTo("file", &buf); err != nil || buf.String() != "test" {
+		t.Errorf("got %q, %v", buf.String(), err)
+	}
+	if err := d.StorEncrypted("secret", strings.NewReader("data"), make([]byte, 32)); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("StorEncrypted gave %v, want ErrReadOnly", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) != 1 || s.files["file"] == nil {
+		t.Errorf("server files changed: %v", s.files)
+	}
+}
+
+func TestDryRunTrees(t *testing.T) {
+	s := newTestServer(t)
+	s.dirs["tree"] = []string{"type=file;size=1; a", "type=dir; sub"}
+	s.dirs["tree/sub"] = []string{"type=file;size=1; b"}
+	s.files["tree/a"] = []byte("a")
+	s.files["tree/sub/b"] = []byte("b")
+	ftp := s.connect()
+
+	local := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(local, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(local, "dir", "f"), []byte("f"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logged bytes.Buffer
+	d := NewDryRun(ftp, log.New(&logged, "", 0))
+	if err := d.RemoveAll("tree"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Upload(local); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.StorWithMetadata("m", strings.NewReader("m"), time.Date(2015, 8, 13, 22, 48, 45, 0, time.UTC), 0640); err != nil {
+		t.Fatal(err)
+	}
+	want := "dry-run: DELE tree/a\ndry-run: DELE tree/sub/b\ndry-run: RMD tree/sub\ndry-run: RMD tree\n" +
+		"dry-run: MKD dir\ndry-run: STOR dir/f\n" +
+		"dry-run: STOR m\ndry-run: MFMT 20150813224845 m\ndry-run: SITE CHMOD 640 m\n"
+	if logged.String() != want {
+		t.Errorf("logged %q, want %q", logged.String(), want)
+	}
+
+	// Release gives back the session as it was
+	if d.Release() != ftp || ftp.readOnly {
+		t.Error("session still read-only after Release")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) != 2 || len(s.dirs) != 2 {
+		t.Errorf("server changed: files %v, dirs %v", s.files, s.dirs)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	s := newTestServer(t)
+	s.files["report.txt"] = []byte("0123456789")
+	ftp := s.connect()
+	ftp.SetGuard(&Guard{
+		MaxUploadSize:     4,
+		MaxDownloadSize:   5,
+		AllowedExtensions: []string{".txt"},
+		DeniedPrefixes:    []string{"/secret"},
+	})
+
+	for name, err := range map[string]error{
+		"absolute denied path": ftp.Dele("/secret/x.txt"),
+		"relative denied path": ftp.Dele("secret/../secret/x.txt"),
+		"extension":            ftp.Stor("tool.exe", strings.NewReader("")),
+		"upload size":          ftp.Stor("big.txt", strings.NewReader("12345")),
+	} {
+		if !errors.Is(err, ErrGuard) {
+			t.Errorf("%s: got %v, want ErrGuard", name, err)
+		}
+	}
+	if _, err := ftp.RetrTo("report.txt", io.Discard); !errors.Is(err, ErrGuard) {
+		t.Errorf("download size: got %v, want ErrGuard", err)
+	}
+}
+
+func TestGuardKeepsSessionInStep(t *testing.T) {
+	s := newTestServer(t)
+	s.files["report.txt"] = []byte("0123456789")
+	// Sizes are only found out during the transfers
+	s.replies["SIZE"] = "502 not implemented"
+	ftp := s.connect()
+	ftp.SetGuard(&Guard{MaxUploadSize: 4, MaxDownloadSize: 5})
+
+	if _, err := ftp.RetrTo("report.txt", io.Discard); !errors.Is(err, ErrGuard) {
+		t.Errorf("download: got %v, want ErrGuard", err)
+	}
+	if err := ftp.Noop(); err != nil {
+		t.Fatalf("Noop after a refused download: %v", err)
+	}
+
+	unsized := io.MultiReader(strings.NewReader("12345"))
+	if err := ftp.Stor("big.txt", unsized); !errors.Is(err, ErrGuard) {
+		t.Errorf("upload: got %v, want ErrGuard", err)
+	}
+	if err := ftp.Noop(); err != nil {
+		t.Fatalf("Noop after a refused upload: %v", err)
+	}
+	if _, err := ftp.Pwd(); err != nil {
+		t.Errorf("Pwd after the refused transfers: %v", err)
+	}
+}
+
+func TestListLazySpillFailure(t *testing.T) {
+	s := newTestServer(t)
+	for i := 0; i < 20; i++ {
+		s.dirs["huge"] = append(s.dirs["huge"], fmt.Sprintf("type=file;size=%d; f%d", i, i))
+	}
+	ftp := s.connect()
+	ftp.SetListSpillThreshold(10)
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+
+	if _, err := ftp.ListLazy("huge"); err == nil {
+		t.Fatal("listing spilled to a missing directory")
+	}
+	if err := ftp.Noop(); err != nil {
+		t.Errorf("Noop after the failed listing: %v", err)
+	}
+}
+
+func TestContextDataDial(t *testing.T) {
+	s := newTestServer(t)
+	s.files["file"] = []byte("content")
+
+	// A proxy dialer, which blackholes data connections once stall is set
+	var mu sync.Mutex
+	var dials int
+	stall := make(chan struct{})
+	stalled := false
+	dial := func(network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dials++
+		blackhole := stalled
+		mu.Unlock()
+		if blackhole {
+			<-stall
+			return nil, errors.New("unreachable")
+		}
+		return net.Dial(network, addr)
+	}
+	defer close(stall)
+
+	ftp, err := ConnectViaContext(context.Background(), s.Addr(), dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ftp.Close()
+	if err = ftp.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ftp.RetrTo("file", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if dials != 2 {
+		t.Errorf("dialer used %d times, want for the control and data connections", dials)
+	}
+	stalled = true
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = ftp.RetrContext(ctx, "file", func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("data dial outlived the context by %v", elapsed)
+	}
+}
+
+func TestPathMapper(t *testing.T) {
+	s := newTestServer(t)
+	s.files["deploy/old"] = []byte("old")
+	s.dirs["deploy/dir"] = []string{"type=file;size=1; a"}
+	ftp := s.connect()
+	ftp.SetPathMapper(func(path string) string { return "deploy/" + path })
+
+	if err := ftp.Stor("new", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ftp.Rename("old", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := ftp.List("dir"); err != nil || len(entries) != 1 {
+		t.Fatalf("got %v, %v", entries, err)
+	}
+	var buf bytes.Buffer
+	if _, err := ftp.RetrTo("renamed", &buf); err != nil || buf.String() != "old" {
+		t.Fatalf("got %q, %v", buf.String(), err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if string(s.files["deploy/new"]) != "new" || string(s.files["deploy/renamed"]) != "old" || len(s.files) != 2 {
+		t.Errorf("unexpected files %v", s.files)
+	}
+}
+
+func TestChainPathMappers(t *testing.T) {
+	s := newTestServer(t)
+	ftp := s.connect()
+	ftp.SetPathMapper(ChainPathMappers(
+		func(path string) string { return "/C:/deploy/" + path },
+		WindowsPathMapper(),
+	))
+
+	if err := ftp.Stor("dir/file", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[`C:\deploy\dir\file`]; !ok || len(s.files) != 1 {
+		t.Errorf("unexpected files %v", s.files)
+	}
+}
+
+func TestListRaw(t *testing.T) {
+	lines := []string{"type=file;size=3; caf\xe9.txt ", "type=dir; sub"}
+	s := newTestServer(t)
+	s.dirs["dir"] = lines
+	ftp := s.connect()
+
+	raw, err := ftp.ListRaw("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != len(lines) {
+		t.Fatalf("got %d lines, want %d", len(raw), len(lines))
+	}
+	for i, line := range raw {
+		if want := lines[i] + "\r\n"; !bytes.Equal(line, []byte(want)) {
+			t.Errorf("line %d is %q, want %q", i, line, want)
+		}
+	}
+
+	files, err := ftp.List2("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != lines[0]+"\r\n" || files[1] != lines[1]+"\r\n" {
+		t.Errorf("unexpected List2 lines %q", files)
+	}
+	if n := s.countVerb("LIST", 0); n != 0 {
+		t.Errorf("LIST sent %d times with MLSD", n)
+	}
+}
+
+func TestListRawFallsBackToList(t *testing.T) {
+	line := "-rw-r--r--   1 ftp ftp          42 Jun 10  1994 COPYING"
+	s := newTestServer(t)
+	s.replies["MLSD"] = "500 MLSD not understood"
+	s.dirs["dir"] = []string{line}
+	ftp := s.connect()
+
+	for i := 0; i < 2; i++ {
+		files, err := ftp.List2("dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 || files[0] != line+"\r\n" {
+			t.Errorf("unexpected lines %q", files)
+		}
+	}
+	if n := s.countVerb("LIST", 0); n != 2 {
+		t.Errorf("LIST sent %d times, want 2", n)
+	}
+	if err := ftp.Noop(); err != nil {
+		t.Errorf("session out of step: %v", err)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	s := newTestServer(t)
+	s.files["a"] = []byte("a")
+	s.files["c"] = []byte("c")
+	ftp := s.connect()
+
+	err := ftp.DeleteMany([]string{"a", "b", "c"})
+	var batch *BatchError
+	if !errors.As(err, &batch) {
+		t.Fatalf("got %v, want a *BatchError", err)
+	}
+	if len(batch.Errors) != 1 || batch.Errors[0].Path != "b" || batch.Errors[0].Code != StatusFileUnavailable {
+		t.Errorf("unexpected failures %+v", batch.Errors)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) != 0 {
+		t.Errorf("files left after the failure: %v", s.files)
+	}
+}
+
+func TestUploadMany(t *testing.T) {
+	s := newTestServer(t)
+	s.once["STOR"] = "553 file name not allowed"
+	ftp := s.connect()
+
+	local := t.TempDir()
+	for _, name := range []string{"refused", "kept"} {
+		if err := os.WriteFile(filepath.Join(local, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths := []string{filepath.Join(local, "refused"), filepath.Join(local, "kept"), filepath.Join(local, "missing")}
+
+	err := ftp.UploadMany(paths)
+	var batch *BatchError
+	if !errors.As(err, &batch) {
+		t.Fatalf("got %v, want a *BatchError", err)
+	}
+	if len(batch.Errors) != 2 {
+		t.Fatalf("unexpected failures %+v", batch.Errors)
+	}
+	if e := batch.Errors[0]; e.Path != paths[0] || e.Code != StatusBadFileName {
+		t.Errorf("unexpected refused upload %+v", e)
+	}
+	if e := batch.Errors[1]; e.Path != paths[2] || e.Code != 0 || !errors.Is(e, os.ErrNotExist) {
+		t.Errorf("unexpected missing upload %+v", e)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Error("errors.Is should find the missing file")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if string(s.files["kept"]) != "kept" || len(s.files) != 1 {
+		t.Errorf("unexpected files %v", s.files)
+	}
+}
+
+func TestStorWithMetadata(t *testing.T) {
+	s := newTestServer(t)
+	ftp := s.connect()
+	modTime := time.Date(2015, 8, 13, 22, 48, 45, 0, time.UTC)
+
+	n := s.commands()
+	if err := ftp.StorWithMetadata("file", strings.NewReader("data"), modTime, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	verbs := strings.Join(s.verbs[n:], " ")
+	s.mu.Unlock()
+	if !strings.HasSuffix(verbs, "STOR MFMT") {
+		t.Errorf("MFMT not sent after STOR: %s", verbs)
+	}
+	if got, err := ftp.ModTime("file"); err != nil || !got.Equal(modTime) {
+		t.Errorf("got %s, %v, want %s", got, err, modTime)
+	}
+
+	// Without MFMT nor SITE CHMOD, the upload alone is done
+	s.mu.Lock()
+	s.replies["MFMT"] = "502 not implemented"
+	s.mu.Unlock()
+	if err := ftp.StorWithMetadata("other", strings.NewReader("data"), modTime, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ftp.Mfmt("other", modTime); replyCode(err) != StatusCommandNotImplemented {
+		t.Errorf("Mfmt gave %v, want a 502 reply", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if string(s.files["other"]) != "data" {
+		t.Errorf("upload missing: %v", s.files)
+	}
+}
+
+func TestModTime(t *testing.T) {
+	s := newTestServer(t)
+	s.files["file"] = nil
+	ftp := s.connect()
+
+	for reply, want := range map[string]time.Time{
+		"213 20150813224845":     time.Date(2015, 8, 13, 22, 48, 45, 0, time.UTC),
+		"213 20150813224845.123": time.Date(2015, 8, 13, 22, 48, 45, 0, time.UTC),
+		"213 2015":               {},
+	} {
+		s.mu.Lock()
+		s.once["MDTM"] = reply
+		s.mu.Unlock()
+		got, err := ftp.ModTime("file")
+		if want.IsZero() {
+			if err == nil {
+				t.Errorf("%q: parsed as %s", reply, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(want) {
+			t.Errorf("%q: got %s, %v, want %s", reply, got, err, want)
+		}
+	}
+
+	s.mu.Lock()
+	s.once["MDTM"] = "502 not implemented"
+	s.mu.Unlock()
+	if _, err := ftp.ModTime("file"); replyCode(err) != StatusCommandNotImplemented {
+		t.Errorf("got %v, want a 502 reply", err)
+	}
+}
+
+func TestLoginWith(t *testing.T) {
+	s := newTestServer(t)
+	ftp, err := Connect(s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ftp.Close()
+
+	// A failing provider is reported before anything is sent
+	vaultErr := errors.New("vault sealed")
+	n := s.commands()
+	if err := ftp.LoginWith(CredentialFunc(func() (string, string, error) {
+		return "", "", vaultErr
+	})); !errors.Is(err, vaultErr) {
+		t.Errorf("got %v, want the provider's error", err)
+	}
+	if s.commands() != n {
+		t.Error("commands sent despite the provider failing")
+	}
+
+	calls := 0
+	provider := CredentialFunc(func() (string, string, error) {
+		calls++
+		return "user", "secret", nil
+	})
+	for i := 0; i < 2; i++ {
+		if err := ftp.LoginWith(provider); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("provider asked %d times, want on every login", calls)
+	}
+	if n := s.countVerb("PASS", n); n != 2 {
+		t.Errorf("PASS sent %d times, want 2", n)
+	}
+
+	// A rejected password is returned as the reply
+	s.mu.Lock()
+	s.once["PASS"] = "530 login incorrect"
+	s.mu.Unlock()
+	if err := ftp.LoginWith(StaticCredentials{Username: "user", Password: "wrong"}); replyCode(err) != StatusNotLoggedIn {
+		t.Errorf("got %v, want a 530 reply", err)
+	}
+}
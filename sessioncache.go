@@ -0,0 +1,200 @@
+package goftp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// SessionCache keeps logged in sessions idle between uses, keyed by address
+// and credentials, so programs issuing several short operations do not pay
+// connect and login every time. It is safe for concurrent use.
+type SessionCache struct {
+	idleTimeout   time.Duration
+	warmUpTimeout time.Duration
+
+	mu    sync.Mutex
+	idle  map[string][]idleSession
+	inUse map[*FTP]cachedSession
+}
+
+// cachedSession is what the cache knows of a session in use
+type cachedSession struct {
+	key  string
+	home string // working directory after login, if warm-up is enabled
+}
+
+type idleSession struct {
+	cachedSession
+	ftp   *FTP
+	since time.Time
+}
+
+// NewSessionCache returns a cache quitting sessions idle for longer than
+// idleTimeout, or than three quarters of the inactivity timeout announced by
+// the server if that is shorter, so an idle session is dropped by the cache
+// before the server drops it
+func NewSessionCache(idleTimeout time.Duration) *SessionCache {
+	return &SessionCache{
+		idleTimeout: idleTimeout,
+		idle:        map[string][]idleSession{},
+		inUse:       map[*FTP]cachedSession{},
+	}
+}
+
+// SetWarmUp makes Get check an idle session more thoroughly before handing
+// it out: NOOP must be answered within timeout, and the session is put back
+// in the directory it was logged in to, in binary mode. A session failing
+// this is replaced by a new one, so the first call after a long idle period
+// does not fail on a connection the server dropped. Zero, the default, only
+// checks that NOOP is answered. Call it before the first Get.
+func (c *SessionCache) SetWarmUp(timeout time.Duration) {
+	c.warmUpTimeout = timeout
+}
+
+// Get returns a session to addr logged in as username, reusing an idle one
+// when it still answers NOOP. Hand it back with Put once done, or Quit it.
+func (c *SessionCache) Get(addr string, username string, password string) (*FTP, error) {
+	key := sessionKey(addr, username, password)
+
+	for {
+		session, ok := c.takeIdle(key)
+		if !ok {
+			break
+		}
+		if err := c.warmUp(session); err == nil {
+			c.markInUse(session.ftp, session.cachedSession)
+			return session.ftp, nil
+		}
+		session.ftp.Close()
+	}
+
+	ftp, err := Connect(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = ftp.Login(username, password); err != nil {
+		ftp.Close()
+		return nil, err
+	}
+
+	session := cachedSession{key: key}
+	if c.warmUpTimeout > 0 {
+		if session.home, err = ftp.Pwd(); err != nil {
+			ftp.Close()
+			return nil, err
+		}
+	}
+
+	c.markInUse(ftp, session)
+	return ftp, nil
+}
+
+// warmUp validates an idle session and resets its state, see SetWarmUp
+func (c *SessionCache) warmUp(session idleSession) error {
+	ftp := session.ftp
+	timeout := c.warmUpTimeout
+	if timeout <= 0 {
+		return ftp.Noop()
+	}
+
+	if err := ftp.setDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer ftp.setDeadline(time.Time{})
+
+	if err := ftp.Noop(); err != nil {
+		return err
+	}
+	if session.home != "" {
+		if err := ftp.Cwd(session.home); err != nil {
+			return err
+		}
+	}
+	return ftp.Type(TypeImage)
+}
+
+// Put hands a session obtained from Get back to the cache
+func (c *SessionCache) Put(ftp *FTP) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	session, ok := c.inUse[ftp]
+	if !ok {
+		return
+	}
+	delete(c.inUse, ftp)
+	c.idle[session.key] = append(c.idle[session.key], idleSession{cachedSession: session, ftp: ftp, since: time.Now()})
+	c.evictLocked()
+}
+
+// Close quits all idle sessions. Sessions in use are left to their users.
+func (c *SessionCache) Close() {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = map[string][]idleSession{}
+	c.mu.Unlock()
+
+	for _, sessions := range idle {
+		for _, s := range sessions {
+			s.ftp.Quit()
+		}
+	}
+}
+
+// takeIdle removes and returns the most recently used idle session of key
+func (c *SessionCache) takeIdle(key string) (idleSession, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictLocked()
+	sessions := c.idle[key]
+	if len(sessions) == 0 {
+		return idleSession{}, false
+	}
+	session := sessions[len(sessions)-1]
+	c.idle[key] = sessions[:len(sessions)-1]
+	return session, true
+}
+
+func (c *SessionCache) markInUse(ftp *FTP, session cachedSession) {
+	c.mu.Lock()
+	c.inUse[ftp] = session
+	c.mu.Unlock()
+}
+
+// evictLocked closes the sessions idle for longer than their idle timeout
+func (c *SessionCache) evictLocked() {
+	now := time.Now()
+	for key, sessions := range c.idle {
+		kept := sessions[:0]
+		for _, s := range sessions {
+			if now.Sub(s.since) > c.idleTimeoutOf(s.ftp) {
+				// The server may have dropped it already, so do not wait for QUIT
+				s.ftp.Close()
+			} else {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			delete(c.idle, key)
+		} else {
+			c.idle[key] = kept
+		}
+	}
+}
+
+// idleTimeoutOf returns how long ftp may stay idle in the cache
+func (c *SessionCache) idleTimeoutOf(ftp *FTP) time.Duration {
+	if server, ok := ftp.IdleTimeout(); ok && server*3/4 < c.idleTimeout {
+		return server * 3 / 4
+	}
+	return c.idleTimeout
+}
+
+// sessionKey identifies the credentials without keeping the password
+func sessionKey(addr string, username string, password string) string {
+	sum := sha256.Sum256([]byte(addr + "\x00" + username + "\x00" + password))
+	return hex.EncodeToString(sum[:])
+}
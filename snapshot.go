@@ -0,0 +1,144 @@
+package goftp
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// SessionSnapshot captures the parameters of a session, to establish an
+// equivalent session elsewhere with RestoreSession, e.g. when a transfer
+// daemon hands its work over during a deploy. It can be serialized as JSON.
+// Credentials are not part of it, nor are the settings holding functions or
+// TLS configurations: the dialer, the PathMapper and the Guard.
+type SessionSnapshot struct {
+	Addr string `json:"addr"`
+	TLS  bool   `json:"tls"` // whether the control connection was secured with AuthTLS
+
+	Dir  string   `json:"dir"`
+	Type TypeCode `json:"type,omitempty"`
+
+	Location           string        `json:"location,omitempty"`
+	LocationOffset     int           `json:"location_offset,omitempty"` // seconds east of UTC, for fixed zones
+	LenientList        bool          `json:"lenient_list,omitempty"`
+	ListSpillThreshold int           `json:"list_spill_threshold,omitempty"`
+	AuthTimeout        time.Duration `json:"auth_timeout,omitempty"`
+	RequireTLS         bool          `json:"require_tls,omitempty"`
+	PassivePortMin     int           `json:"passive_port_min,omitempty"`
+	PassivePortMax     int           `json:"passive_port_max,omitempty"`
+	DataMode           DataMode      `json:"data_mode,omitempty"`
+	ActivePortMin      int           `json:"active_port_min,omitempty"`
+	ActivePortMax      int           `json:"active_port_max,omitempty"`
+	ActiveAddress      string        `json:"active_address,omitempty"`
+	ReadOnly           bool          `json:"read_only,omitempty"`
+	Allocate           bool          `json:"allocate,omitempty"`
+	MappedUploads      bool          `json:"mapped_uploads,omitempty"`
+	HashAlgorithm      string        `json:"hash_algorithm,omitempty"`
+	Language           string        `json:"language,omitempty"`
+	Features           []string      `json:"features,omitempty"` // FEAT lines, if queried
+}
+
+// Snapshot captures the parameters of the session, asking the server for the
+// working directory
+func (ftp *FTP) Snapshot() (*SessionSnapshot, error) {
+	dir, err := ftp.Pwd()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SessionSnapshot{
+		Addr:               ftp.addr,
+		TLS:                ftp.tlsconfig != nil,
+		Dir:                dir,
+		Type:               ftp.transferType,
+		LenientList:        ftp.lenientList,
+		ListSpillThreshold: ftp.listSpill,
+		AuthTimeout:        ftp.authTimeout,
+		RequireTLS:         ftp.requireTLS,
+		PassivePortMin:     ftp.passiveMin,
+		PassivePortMax:     ftp.passiveMax,
+		DataMode:           ftp.dataMode,
+		ActivePortMin:      ftp.activeMin,
+		ActivePortMax:      ftp.activeMax,
+		ActiveAddress:      ftp.activeAddr,
+		ReadOnly:           ftp.readOnly,
+		Allocate:           ftp.allocate,
+		MappedUploads:      ftp.mappedUploads,
+		HashAlgorithm:      ftp.hashAlgorithm,
+		Language:           ftp.language,
+		Features:           ftp.rawFeatures,
+	}
+	if ftp.location != nil {
+		s.Location = ftp.location.String()
+		_, s.LocationOffset = time.Now().In(ftp.location).Zone()
+	}
+	return s, nil
+}
+
+// RestoreSession connects to the server of the snapshot, secures the
+// connection with config if the snapshot session was, logs in with
+// credentials and applies the parameters of the snapshot.
+func RestoreSession(s *SessionSnapshot, credentials CredentialProvider, config *tls.Config) (ftp *FTP, err error) {
+	if ftp, err = connect(s.Addr, nil, false); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			ftp.Close()
+			ftp = nil
+		}
+	}()
+
+	if s.Location != "" || s.LocationOffset != 0 {
+		// Zones made with time.FixedZone are not in the time zone database,
+		// and may have no name
+		if ftp.location, err = time.LoadLocation(s.Location); err != nil || s.Location == "" {
+			ftp.location, err = time.FixedZone(s.Location, s.LocationOffset), nil
+		}
+	}
+	ftp.lenientList = s.LenientList
+	ftp.listSpill = s.ListSpillThreshold
+	ftp.authTimeout = s.AuthTimeout
+	ftp.requireTLS = s.RequireTLS
+	ftp.passiveMin, ftp.passiveMax = s.PassivePortMin, s.PassivePortMax
+	ftp.dataMode = s.DataMode
+	ftp.activeMin, ftp.activeMax = s.ActivePortMin, s.ActivePortMax
+	ftp.activeAddr = s.ActiveAddress
+	ftp.allocate = s.Allocate
+	ftp.mappedUploads = s.MappedUploads
+	ftp.readOnly = s.ReadOnly
+	if s.Features != nil {
+		ftp.setFeatures(s.Features)
+	}
+
+	if s.TLS {
+		if err = ftp.AuthTLS(config); err != nil {
+			return
+		}
+	}
+	if err = ftp.LoginWith(credentials); err != nil {
+		return
+	}
+
+	if s.Type != "" {
+		if err = ftp.Type(s.Type); err != nil {
+			return
+		}
+	}
+	if s.HashAlgorithm != "" {
+		if err = ftp.SetHashAlgorithm(s.HashAlgorithm); err != nil {
+			return
+		}
+	}
+	if s.Language != "" {
+		if err = ftp.SetLanguage(s.Language); err != nil {
+			return
+		}
+	}
+	if s.Dir != "" {
+		if err = ftp.Cwd(s.Dir); err != nil {
+			return
+		}
+	}
+
+	return ftp, nil
+}
@@ -0,0 +1,59 @@
+package goftp
+
+import (
+	"net"
+	"time"
+)
+
+// TransferStats describes a data transfer of the session: a download, an
+// upload or a listing
+type TransferStats struct {
+	Command string // RETR, STOR, MLSD or LIST
+	Path    string
+	Bytes   int64 // bytes sent or received on the data connection
+
+	// Restarted tells whether the transfer was restarted at Offset with REST
+	Restarted bool
+	Offset    uint64
+
+	Start time.Time
+	// Duration is from opening the data connection to the completion reply
+	Duration time.Duration
+	// Reply is the code of the completion reply, 0 if none was received
+	Reply StatusCode
+
+	// Addresses of the data connection, e.g. to debug NAT or derive
+	// firewall rules
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+}
+
+// LastTransfer returns the statistics of the most recent transfer, or nil if
+// there was none. They are complete once the transfer returned.
+func (ftp *FTP) LastTransfer() *TransferStats {
+	return ftp.lastTransfer
+}
+
+// statsConn counts the bytes of a data connection
+type statsConn struct {
+	net.Conn
+	stats   *TransferStats
+	onClose func(net.Conn)
+}
+
+func (c *statsConn) Close() error {
+	c.onClose(c.Conn)
+	return c.Conn.Close()
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.Bytes += int64(n)
+	return n, err
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.Bytes += int64(n)
+	return n, err
+}
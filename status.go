@@ -1,36 +1,187 @@
 package goftp
 
-// FTP Status codes, defined in RFC 959
+import (
+	"errors"
+	"strconv"
+)
+
+// StatusCode is an FTP reply code
+type StatusCode int
+
+// FTP Status codes, defined in RFC 959 and RFC 2228
 const (
-	StatusFileOK                = "150"
-	StatusOK                    = "200"
-	StatusSystemStatus          = "211"
-	StatusDirectoryStatus       = "212"
-	StatusFileStatus            = "213"
-	StatusConnectionClosing     = "221"
-	StatusSystemType            = "215"
-	StatusClosingDataConnection = "226"
-	StatusActionOK              = "250"
-	StatusPathCreated           = "257"
-	StatusActionPending         = "350"
+	StatusRestartMarker         StatusCode = 110
+	StatusReadyMinute           StatusCode = 120
+	StatusAlreadyOpen           StatusCode = 125
+	StatusFileOK                StatusCode = 150
+	StatusOK                    StatusCode = 200
+	StatusSuperfluous           StatusCode = 202
+	StatusSystemStatus          StatusCode = 211
+	StatusDirectoryStatus       StatusCode = 212
+	StatusFileStatus            StatusCode = 213
+	StatusHelpMessage           StatusCode = 214
+	StatusSystemType            StatusCode = 215
+	StatusReady                 StatusCode = 220
+	StatusConnectionClosing     StatusCode = 221
+	StatusDataConnectionOpen    StatusCode = 225
+	StatusClosingDataConnection StatusCode = 226
+	StatusPassiveMode           StatusCode = 227
+	StatusLoggedIn              StatusCode = 230
+	StatusLoggedInSecurity      StatusCode = 232
+	StatusSecurityExchangeOK    StatusCode = 234
+	StatusSecurityDataOK        StatusCode = 235
+	StatusActionOK              StatusCode = 250
+	StatusPathCreated           StatusCode = 257
+	StatusUserOK                StatusCode = 331
+	StatusNeedAccount           StatusCode = 332
+	StatusSecurityDataAccepted  StatusCode = 334
+	StatusSecurityDataNeeded    StatusCode = 335
+	StatusUserOKChallenge       StatusCode = 336
+	StatusActionPending         StatusCode = 350
+	StatusNotAvailable          StatusCode = 421
+	StatusCanNotOpenDataConn    StatusCode = 425
+	StatusTransferAborted       StatusCode = 426
+	StatusSecurityResource      StatusCode = 431
+	StatusFileActionIgnored     StatusCode = 450
+	StatusActionAborted         StatusCode = 451
+	StatusInsufficientStorage   StatusCode = 452
+	StatusSyntaxError           StatusCode = 500
+	StatusParameterSyntaxError  StatusCode = 501
+	StatusCommandNotImplemented StatusCode = 502
+	StatusBadCommandSequence    StatusCode = 503
+	StatusNotImplementedParam   StatusCode = 504
+	StatusNotLoggedIn           StatusCode = 530
+	StatusStorNeedsAccount      StatusCode = 532
+	StatusProtectionDenied      StatusCode = 533
+	StatusPolicyDenied          StatusCode = 534
+	StatusSecurityCheckFailed   StatusCode = 535
+	StatusProtLevelNotSupported StatusCode = 536
+	StatusProtNotSupported      StatusCode = 537
+	StatusFileUnavailable       StatusCode = 550
+	StatusPageTypeUnknown       StatusCode = 551
+	StatusExceededStorage       StatusCode = 552
+	StatusBadFileName           StatusCode = 553
+	StatusIntegrityProtected    StatusCode = 631
+	StatusConfidentialIntegrity StatusCode = 632
+	StatusConfidentialProtected StatusCode = 633
 )
 
-var statusText = map[string]string{
+var statusText = map[StatusCode]string{
+	StatusRestartMarker:         "Restart marker reply",
+	StatusReadyMinute:           "Service ready in nnn minutes",
+	StatusAlreadyOpen:           "Data connection already open; transfer starting",
 	StatusFileOK:                "File status okay; about to open data connection",
 	StatusOK:                    "Command okay",
+	StatusSuperfluous:           "Command not implemented, superfluous at this site",
 	StatusSystemStatus:          "System status, or system help reply",
 	StatusDirectoryStatus:       "Directory status",
 	StatusFileStatus:            "File status",
-	StatusConnectionClosing:     "Service closing control connection",
+	StatusHelpMessage:           "Help message",
 	StatusSystemType:            "System Type",
+	StatusReady:                 "Service ready for new user",
+	StatusConnectionClosing:     "Service closing control connection",
+	StatusDataConnectionOpen:    "Data connection open; no transfer in progress",
 	StatusClosingDataConnection: "Closing data connection. Requested file action successful.",
+	StatusPassiveMode:           "Entering Passive Mode",
+	StatusLoggedIn:              "User logged in, proceed",
+	StatusLoggedInSecurity:      "User logged in, authorized by security data exchange",
+	StatusSecurityExchangeOK:    "Security data exchange complete",
+	StatusSecurityDataOK:        "Security data exchange completed successfully",
 	StatusActionOK:              "Requested file action okay, completed",
 	StatusPathCreated:           "Pathname Created",
+	StatusUserOK:                "User name okay, need password",
+	StatusNeedAccount:           "Need account for login",
+	StatusSecurityDataAccepted:  "Security mechanism accepted, security data required",
+	StatusSecurityDataNeeded:    "Security data accepted, more required",
+	StatusUserOKChallenge:       "Username okay, need password. Challenge is ...",
 	StatusActionPending:         "Requested file action pending further information",
+	StatusNotAvailable:          "Service not available, closing control connection",
+	StatusCanNotOpenDataConn:    "Can't open data connection",
+	StatusTransferAborted:       "Connection closed; transfer aborted",
+	StatusSecurityResource:      "Need some unavailable resource to process security",
+	StatusFileActionIgnored:     "Requested file action not taken",
+	StatusActionAborted:         "Requested action aborted: local error in processing",
+	StatusInsufficientStorage:   "Requested action not taken. Insufficient storage space in system",
+	StatusSyntaxError:           "Syntax error, command unrecognized",
+	StatusParameterSyntaxError:  "Syntax error in parameters or arguments",
+	StatusCommandNotImplemented: "Command not implemented",
+	StatusBadCommandSequence:    "Bad sequence of commands",
+	StatusNotImplementedParam:   "Command not implemented for that parameter",
+	StatusNotLoggedIn:           "Not logged in",
+	StatusStorNeedsAccount:      "Need account for storing files",
+	StatusProtectionDenied:      "Command protection level denied for policy reasons",
+	StatusPolicyDenied:          "Request denied for policy reasons",
+	StatusSecurityCheckFailed:   "Failed security check",
+	StatusProtLevelNotSupported: "Data protection level not supported by security mechanism",
+	StatusProtNotSupported:      "Command protection level not supported by security mechanism",
+	StatusFileUnavailable:       "Requested action not taken. File unavailable",
+	StatusPageTypeUnknown:       "Requested action aborted: page type unknown",
+	StatusExceededStorage:       "Requested file action aborted. Exceeded storage allocation",
+	StatusBadFileName:           "Requested action not taken. File name not allowed",
+	StatusIntegrityProtected:    "Integrity protected reply",
+	StatusConfidentialIntegrity: "Confidentiality and integrity protected reply",
+	StatusConfidentialProtected: "Confidentiality protected reply",
 }
 
 // StatusText returns a text for the FTP status code. It returns the empty
 // string if the code is unknown.
-func StatusText(code string) string {
+func StatusText(code StatusCode) string {
 	return statusText[code]
 }
+
+// IsPositivePreliminary reports a 1yz reply: the action is being started,
+// expect another reply before the next command
+func (code StatusCode) IsPositivePreliminary() bool {
+	return code/100 == 1
+}
+
+// IsPositiveCompletion reports a 2yz reply: the action completed
+func (code StatusCode) IsPositiveCompletion() bool {
+	return code/100 == 2
+}
+
+// IsPositiveIntermediate reports a 3yz reply: the command was accepted but
+// further information is needed
+func (code StatusCode) IsPositiveIntermediate() bool {
+	return code/100 == 3
+}
+
+// IsTransientNegative reports a 4yz reply: the action was not taken but may
+// succeed if retried
+func (code StatusCode) IsTransientNegative() bool {
+	return code/100 == 4
+}
+
+// IsPermanentNegative reports a 5yz reply: the action was not taken and
+// retrying the same command will not help
+func (code StatusCode) IsPermanentNegative() bool {
+	return code/100 == 5
+}
+
+// IsProtected reports a 6yz reply, a protected reply as of RFC 2228
+func (code StatusCode) IsProtected() bool {
+	return code/100 == 6
+}
+
+// parseReplyCode returns the reply code a reply line starts with, or 0
+func parseReplyCode(line string) StatusCode {
+	if len(line) < 3 {
+		return 0
+	}
+	code, err := strconv.Atoi(line[:3])
+	if err != nil {
+		return 0
+	}
+	return StatusCode(code)
+}
+
+// replyCode returns the reply code of an error built from a server reply, or 0
+// if err is not a reply. Wrapped errors are looked through.
+func replyCode(err error) StatusCode {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if code := parseReplyCode(err.Error()); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package goftp
+
+import "testing"
+
+func TestStatusCodeClasses(t *testing.T) {
+	for _, test := range []struct {
+		code  StatusCode
+		class int // reply class: first digit, or 0 for none
+	}{
+		{99, 0},
+		{100, 1},
+		{150, 1},
+		{199, 1},
+		{200, 2},
+		{226, 2},
+		{299, 2},
+		{300, 3},
+		{350, 3},
+		{399, 3},
+		{400, 4},
+		{425, 4},
+		{499, 4},
+		{500, 5},
+		{550, 5},
+		{599, 5},
+		{600, 6},
+		{631, 6},
+		{700, 0},
+	} {
+		got := map[int]bool{
+			1: test.code.IsPositivePreliminary(),
+			2: test.code.IsPositiveCompletion(),
+			3: test.code.IsPositiveIntermediate(),
+			4: test.code.IsTransientNegative(),
+			5: test.code.IsPermanentNegative(),
+			6: test.code.IsProtected(),
+		}
+		for class, is := range got {
+			if is != (class == test.class) {
+				t.Errorf("%d: class %d helper gave %v", test.code, class, is)
+			}
+		}
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	for code, want := range map[StatusCode]string{
+		StatusFileOK:                "File status okay; about to open data connection",
+		StatusClosingDataConnection: "Closing data connection. Requested file action successful.",
+		StatusFileUnavailable:       "Requested action not taken. File unavailable",
+		StatusOK:                    "Command okay",
+		199:                         "",
+		399:                         "",
+		400:                         "",
+		599:                         "",
+		0:                           "",
+	} {
+		if got := StatusText(code); got != want {
+			t.Errorf("StatusText(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package goftp
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// ServerSystem is the operating system of the server, as reported by SYST
+type ServerSystem int
+
+// The server systems told apart by System
+const (
+	SystemUnknown ServerSystem = iota
+	SystemUnix
+	SystemWindowsNT
+	SystemVMS
+	SystemMVS
+)
+
+var serverSystemNames = map[ServerSystem]string{
+	SystemUnknown:   "Unknown",
+	SystemUnix:      "Unix",
+	SystemWindowsNT: "WindowsNT",
+	SystemVMS:       "VMS",
+	SystemMVS:       "MVS",
+}
+
+func (s ServerSystem) String() string {
+	return serverSystemNames[s]
+}
+
+// parseSystem returns the ServerSystem of a SYST reply text
+func parseSystem(syst string) ServerSystem {
+	syst = strings.ToUpper(syst)
+	switch {
+	case strings.HasPrefix(syst, "UNIX"):
+		return SystemUnix
+	case strings.HasPrefix(syst, "WINDOWS_NT"):
+		return SystemWindowsNT
+	case strings.HasPrefix(syst, "VMS"):
+		return SystemVMS
+	case strings.HasPrefix(syst, "MVS"):
+		return SystemMVS
+	}
+	return SystemUnknown
+}
+
+// System returns the system of the remote host, sending SYST the first time.
+// It is used to pick the most likely LIST format first. Paths are sent as
+// given whatever the system, e.g. in VMS or MVS syntax by the caller.
+func (ftp *FTP) System() (ServerSystem, error) {
+	if !ftp.systemKnown {
+		if _, err := ftp.Syst(); err != nil {
+			return SystemUnknown, err
+		}
+	}
+	return ftp.system, nil
+}
+
+// detectSystem finds out the system before the first LIST, once: a server
+// failing SYST is not asked again and keeps the system guessed so far
+func (ftp *FTP) detectSystem() {
+	if ftp.systemKnown {
+		return
+	}
+	if _, err := ftp.System(); err != nil {
+		if ftp.debug {
+			log.Printf("SYST failed, keeping %s: %v", ftp.system, err)
+		}
+		ftp.systemKnown = true
+	}
+}
+
+// windowsListLineParsers tries the DIR format first
+var windowsListLineParsers = []parseFunc{
+	parseDirListLine,
+	parseRFC3659ListLine,
+	parseLsListLine,
+	parseHostedFTPLine,
+}
+
+// listLineParser returns a parser of LIST lines trying the formats in the
+// order most likely for the server system, as far as it is known
+func (ftp *FTP) listLineParser() parseFunc {
+	if ftp.system != SystemWindowsNT {
+		return parseListLine
+	}
+
+	return func(line string, now time.Time, loc *time.Location) (*Entry, error) {
+		return parseListLineWith(windowsListLineParsers, line, now, loc)
+	}
+}
@@ -0,0 +1,294 @@
+package goftp
+
+import (
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CompareResult is the outcome of comparing a local and a remote file
+type CompareResult int
+
+// The outcomes of CompareTree and CompareRemotes
+const (
+	CompareSame CompareResult = iota
+	CompareSizeDiffers
+	CompareTimeDiffers
+	CompareOnlyLocal
+	CompareOnlyRemote
+	CompareOnlyPrimary
+	CompareOnlyMirror
+)
+
+// Comparison describes a file found in the local or the remote tree
+type Comparison struct {
+	Path   string // slash separated, relative to the compared roots
+	Result CompareResult
+	Local  os.FileInfo // nil if only remote
+	Remote *Entry      // nil if only local
+}
+
+// RemoteComparison describes a file found on the primary or the mirror host
+type RemoteComparison struct {
+	Path    string // slash separated, relative to the compared roots
+	Result  CompareResult
+	Primary *Entry // nil if only on the mirror
+	Mirror  *Entry // nil if only on the primary
+}
+
+// DirSize walks the remote tree at path and returns the total size and the
+// number of regular files in it. Sizes come from the MLSD facts when the
+// server supports it, and from the LIST output otherwise.
+func (ftp *FTP) DirSize(path string) (bytes uint64, files int, err error) {
+	err = ftp.walkEntries(path, "", func(rel string, e *Entry) {
+		if e.Type == EntryTypeFile {
+			bytes += e.Size
+			files++
+		}
+	})
+	return
+}
+
+// CompareTree compares the regular files of the local tree at localPath with
+// the remote tree at remotePath, by size and modification time. Times are
+// considered the same within a minute, the precision of most LIST formats.
+// The comparisons are sorted by path.
+func (ftp *FTP) CompareTree(localPath, remotePath string) ([]Comparison, error) {
+	remote := map[string]*Entry{}
+	err := ftp.walkEntries(remotePath, "", func(rel string, e *Entry) {
+		if e.Type == EntryTypeFile {
+			remote[rel] = e
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var comparisons []Comparison
+	err = filepath.Walk(localPath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(localPath, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		c := Comparison{Path: rel, Local: fi, Remote: remote[rel]}
+		switch {
+		case c.Remote == nil:
+			c.Result = CompareOnlyLocal
+		case c.Remote.Size != uint64(fi.Size()):
+			c.Result = CompareSizeDiffers
+		case !c.Remote.Time.IsZero() && absDuration(c.Remote.Time.Sub(fi.ModTime())) >= time.Minute:
+			c.Result = CompareTimeDiffers
+		}
+		delete(remote, rel)
+		comparisons = append(comparisons, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for rel, e := range remote {
+		comparisons = append(comparisons, Comparison{Path: rel, Result: CompareOnlyRemote, Remote: e})
+	}
+
+	sort.Slice(comparisons, func(i, j int) bool {
+		return comparisons[i].Path < comparisons[j].Path
+	})
+	return comparisons, nil
+}
+
+// CompareRemotes compares the regular files of the tree at path on primary
+// with the same tree on mirror, by size and modification time, to verify a
+// replication. Times are considered the same within a minute, and are only
+// compared when both servers report them. The comparisons are sorted by path.
+func CompareRemotes(primary, mirror *FTP, path string) ([]RemoteComparison, error) {
+	mirrored := map[string]*Entry{}
+	err := mirror.walkEntries(path, "", func(rel string, e *Entry) {
+		if e.Type == EntryTypeFile {
+			mirrored[rel] = e
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var comparisons []RemoteComparison
+	err = primary.walkEntries(path, "", func(rel string, e *Entry) {
+		if e.Type != EntryTypeFile {
+			return
+		}
+
+		c := RemoteComparison{Path: rel, Primary: e, Mirror: mirrored[rel]}
+		switch {
+		case c.Mirror == nil:
+			c.Result = CompareOnlyPrimary
+		case c.Mirror.Size != e.Size:
+			c.Result = CompareSizeDiffers
+		case !e.Time.IsZero() && !c.Mirror.Time.IsZero() && absDuration(c.Mirror.Time.Sub(e.Time)) >= time.Minute:
+			c.Result = CompareTimeDiffers
+		}
+		delete(mirrored, rel)
+		comparisons = append(comparisons, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for rel, e := range mirrored {
+		comparisons = append(comparisons, RemoteComparison{Path: rel, Result: CompareOnlyMirror, Mirror: e})
+	}
+
+	sort.Slice(comparisons, func(i, j int) bool {
+		return comparisons[i].Path < comparisons[j].Path
+	})
+	return comparisons, nil
+}
+
+// RemoveAll removes path and everything below it on the remote host. It first
+// asks the server to do it in one command, SITE RMDIR as implemented by
+// ProFTPD's mod_site_misc, and falls back to deleting each file and folder
+// otherwise. A server not implementing SITE RMDIR is not asked again.
+func (ftp *FTP) RemoveAll(path string) error {
+	if err := ftp.checkWritable(); err != nil {
+		return err
+	}
+	if err := ftp.checkPath(path); err != nil {
+		return err
+	}
+
+	if !ftp.noSiteRmdir {
+		line, err := ftp.cmd(StatusActionOK, "SITE RMDIR %s", ftp.remotePath(path))
+		if err == nil || parseReplyCode(line).IsPositiveCompletion() {
+			return nil
+		}
+		if isNotImplemented(err) {
+			ftp.noSiteRmdir = true
+		}
+	}
+
+	return ftp.removeTree(path)
+}
+
+// removeTree removes path one entry at a time
+func (ftp *FTP) removeTree(path string) error {
+	entries, err := ftp.List(path)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if isSelfOrParent(e) {
+			continue
+		}
+		child := pathpkg.Join(path, e.Name)
+		if e.Type == EntryTypeFolder {
+			err = ftp.removeTree(child)
+		} else {
+			err = ftp.Dele(child)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return ftp.Rmd(path)
+}
+
+// WalkEntryFunc is called by WalkEntries for each entry below the root, with
+// the path of the entry. If listing a folder failed, it is called once more
+// for the folder with the error, e is nil for the root. The returned error
+// controls the walk as with filepath.WalkFunc: filepath.SkipDir on a folder
+// skips its content, on a file the remaining entries of its folder;
+// filepath.SkipAll stops the walk without error; any other error stops the
+// walk and is returned by WalkEntries.
+type WalkEntryFunc func(path string, e *Entry, err error) error
+
+// WalkEntries walks the remote tree at root, calling fn for every file and
+// folder with its full Entry, folders before their content. Entries are
+// visited in listing order.
+func (ftp *FTP) WalkEntries(root string, fn WalkEntryFunc) error {
+	err := ftp.walkTree(root, nil, fn)
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkTree lists the folder at path, described by dir, and walks its entries
+func (ftp *FTP) walkTree(path string, dir *Entry, fn WalkEntryFunc) error {
+	entries, err := ftp.List(path)
+	if err != nil {
+		return fn(path, dir, err)
+	}
+
+	for _, e := range entries {
+		if isSelfOrParent(e) {
+			continue
+		}
+		child := pathpkg.Join(path, e.Name)
+		err = fn(child, e, nil)
+		if err == nil && e.Type == EntryTypeFolder {
+			err = ftp.walkTree(child, e, fn)
+		}
+		if err == filepath.SkipDir {
+			if e.Type == EntryTypeFolder {
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkEntries lists the remote tree at path recursively and calls fn for
+// every entry that is not a folder, with its path relative to the root. rel
+// is the relative path of path itself.
+func (ftp *FTP) walkEntries(path, rel string, fn func(rel string, e *Entry)) error {
+	entries, err := ftp.List(path)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.Type != EntryTypeFolder {
+			fn(pathpkg.Join(rel, e.Name), e)
+			continue
+		}
+		if isSelfOrParent(e) {
+			continue
+		}
+		if err = ftp.walkEntries(pathpkg.Join(path, e.Name), pathpkg.Join(rel, e.Name), fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isSelfOrParent reports whether e is the listed directory or its parent,
+// including cdir and pdir MLSD entries which some servers name by full path
+func isSelfOrParent(e *Entry) bool {
+	return e.Name == "." || e.Name == ".." || strings.Contains(e.Name, "/")
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -2,21 +2,19 @@
 package goftp
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 )
 
-func (ftp *FTP) copyDir(localPath string) error {
+// copyDir recreates the tree at localPath in the working directory, creating
+// each folder with mkd and copying each file with copy
+func (ftp *FTP) copyDir(localPath string, mkd func(serverPath string) error, copy func(localPath, serverPath string) error) error {
 	fullPath, err := filepath.Abs(localPath)
 	if err != nil {
 		return err
 	}
 
-	pwd, err := ftp.Pwd()
-	if err != nil {
-		return err
-	}
-
 	walkFunc := func(path string, fi os.FileInfo, err error) error {
 		// Stop upon error
 		if err != nil {
@@ -32,7 +30,7 @@ func (ftp *FTP) copyDir(localPath string) error {
 			if path == fullPath {
 				return nil
 			}
-			if err = ftp.Mkd(relPath); err != nil {
+			if err = mkd(relPath); err != nil {
 				if _, err = ftp.List(relPath + "/"); err != nil {
 					return err
 				}
@@ -43,7 +41,7 @@ func (ftp *FTP) copyDir(localPath string) error {
 				return err
 			}
 			if fInfo.IsDir() {
-				err = ftp.Mkd(relPath)
+				err = mkd(relPath)
 				return err
 			} else if fInfo.Mode()&os.ModeType != 0 {
 				// ignore other special files
@@ -51,7 +49,8 @@ func (ftp *FTP) copyDir(localPath string) error {
 			}
 			fallthrough
 		case fi.Mode()&os.ModeType == 0:
-			if err = ftp.copyFile(path, pwd+"/"+relPath); err != nil {
+			// relative to the working directory, so a PathMapper sees it once
+			if err = copy(path, relPath); err != nil {
 				return err
 			}
 		default:
@@ -65,8 +64,13 @@ func (ftp *FTP) copyDir(localPath string) error {
 }
 
 func (ftp *FTP) copyFile(localPath, serverPath string) (err error) {
-	var file *os.File
-	if file, err = os.Open(localPath); err != nil {
+	var file io.ReadCloser
+	if ftp.mappedUploads {
+		file, err = OpenMapped(localPath)
+	} else {
+		file, err = os.Open(localPath)
+	}
+	if err != nil {
 		return err
 	}
 	defer file.Close()
@@ -88,7 +92,7 @@ func (ftp *FTP) Upload(localPath string) (err error) {
 
 	switch {
 	case fInfo.IsDir():
-		return ftp.copyDir(localPath)
+		return ftp.copyDir(localPath, ftp.Mkd, ftp.copyFile)
 	case fInfo.Mode()&os.ModeType == 0:
 		return ftp.copyFile(localPath, filepath.Base(localPath))
 	default: